@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -15,52 +19,225 @@ import (
 )
 
 type FetchCmd struct {
+	ID     []int    `arg:"" optional:"" help:"Subscription ids to fetch, due or not."`
+	Tag    []string `short:"g" help:"Only fetch subscriptions with this tag or under it (tech matches tech/news)."`
+	URL    []string `short:"u" help:"Only fetch subscriptions whose url matches this glob (* and ?)."`
+	All    bool     `help:"Fetch every subscription, including those not due yet."`
+	DryRun bool     `short:"n" help:"Download and process the feeds, but only print what would be stored."`
+	Format string   `short:"f" default:"text" enum:"text,json" help:"Dry run output format. json prints the run report."`
+	Report string   `help:"Write a JSON run report to a file, - for stdout or store:KEY for a key of the store (e.g. store:status.json)."`
+
+	MetricsFile string `env:"SRR_METRICS_FILE" help:"Write Prometheus metrics of the run to this file, for the node exporter textfile collector. Not in dry runs."`
+	MetricsPush string `env:"SRR_METRICS_PUSH" help:"Push Prometheus metrics of the run to this Pushgateway url. Not in dry runs."`
+}
+
+// writeMetrics outputs the metrics of a run where they were asked for.
+func (o *FetchCmd) writeMetrics(ctx context.Context, client *http.Client) error {
+	if o.MetricsFile != "" {
+		if err := metrics.writeFile(o.MetricsFile); err != nil {
+			return fmt.Errorf("writing metrics: %w", err)
+		}
+	}
+	if o.MetricsPush != "" {
+		if err := metrics.push(ctx, client, o.MetricsPush); err != nil {
+			return fmt.Errorf("pushing metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// tagMatches reports whether tag is filter or nested under it.
+func tagMatches(tag, filter string) bool {
+	filter = strings.TrimSuffix(filter, "/")
+	return tag == filter || strings.HasPrefix(tag, filter+"/")
+}
+
+// globRegexp compiles a url glob, where * matches any run of characters,
+// slashes included, and ? a single one.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// selected returns the subscriptions a run at now fetches: those given by
+// id, plus the due ones, or all with --all, matching the tag and url
+// filters. Ids without filters fetch only those. Paused subscriptions are
+// never fetched, with a warning when given by id.
+func (o *FetchCmd) selected(subs []*Subscription, now time.Time) ([]*Subscription, error) {
+	ids := make(map[int]bool, len(o.ID))
+	for _, id := range o.ID {
+		ids[id] = true
+	}
+	var globs []*regexp.Regexp
+	for _, g := range o.URL {
+		re, err := globRegexp(g)
+		if err != nil {
+			return nil, fmt.Errorf("url glob %q: %w", g, err)
+		}
+		globs = append(globs, re)
+	}
+
+	filtered := len(o.Tag) > 0 || len(globs) > 0
+	matches := func(s *Subscription) bool {
+		if len(o.Tag) > 0 && !slices.ContainsFunc(o.Tag, func(t string) bool { return tagMatches(s.Tag, t) }) {
+			return false
+		}
+		if len(globs) > 0 && !slices.ContainsFunc(globs, func(re *regexp.Regexp) bool { return re.MatchString(s.URL) }) {
+			return false
+		}
+		return true
+	}
+
+	var due []*Subscription
+	for _, s := range subs {
+		picked := ids[s.ID]
+		delete(ids, s.ID)
+		switch {
+		case s.Paused:
+			if picked {
+				slog.Warn("skipping paused subscription, resume it with add --upd <id> --no-pause", "sub", s)
+			}
+		case picked:
+			due = append(due, s)
+		case len(o.ID) > 0 && !filtered:
+		case (o.All || s.due(now)) && matches(s):
+			due = append(due, s)
+		}
+	}
+	for _, id := range o.ID {
+		if ids[id] {
+			return nil, fmt.Errorf("subscription id %d not found", id)
+		}
+	}
+	return due, nil
 }
 
-func (o *FetchCmd) Run() error {
+func (o *FetchCmd) Run() (err error) {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	start := time.Now()
+
+	if key, ok := strings.CutPrefix(o.Report, "store:"); ok {
+		if o.DryRun {
+			return fmt.Errorf("a dry run cannot write its report to the store")
+		}
+		if err := checkReportKey(key); err != nil {
+			return err
+		}
+	}
+	if err := prepareWebhooks(); err != nil {
+		return err
+	}
 
-	db, err := NewDB(ctx, true)
+	db, err := NewDB(ctx, !o.DryRun)
 	if err != nil {
 		return err
 	}
 	defer db.Close(ctx)
 	db.core.FetchedAt = time.Now().UTC().Unix()
+	metrics.load(db.core.Metrics)
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+	client := newHTTPClient()
 	processor := mod.New()
 
-	ch := make(chan *Subscription, globals.Workers)
+	due, err := o.selected(db.Subscriptions(), time.Now())
+	if err != nil {
+		return err
+	}
+	slog.Debug("fetching subscriptions", "due", len(due), "total", len(db.Subscriptions()))
+
+	queue := newHostQueue(due)
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []*fetchResult
+	var changed []*webhookHealth
 
 	for range globals.Workers {
 		wg.Go(func() {
-			buffer := make([]byte, globals.MaxFeedSize*(1<<10)+1)
-
-			for s := range ch {
-				s.FetchError = ""
-				if err := s.Fetch(ctx, client, buffer, processor); err != nil {
-					s.FetchError = err.Error()
+			for {
+				s, err := queue.take(ctx)
+				if s == nil || err != nil {
+					return
+				}
+				began, was := time.Now(), s.health()
+				err = s.Fetch(ctx, client, processor)
+				if err != nil {
 					s.newItems = nil
+				}
+				switch {
+				case ctx.Err() != nil:
+					// Interrupted runs say nothing about the feed.
+					s.newItems = nil
+					queue.done(s)
+					continue
+				case o.DryRun:
+				case err == nil:
+					s.succeeded(time.Now())
+					s.redirected(s.stats.MovedTo)
+				default:
+					s.failed(time.Now(), err)
 					slog.Error("fetch failed", "sub", s, "err", err)
 				}
+				if !o.DryRun {
+					observeFetch(s.stats, err)
+				}
+
+				r := newFetchResult(s, err, time.Since(began), o.DryRun)
+				mu.Lock()
+				results = append(results, r)
+				if c := healthChange(s, was); c != nil {
+					changed = append(changed, c)
+				}
+				mu.Unlock()
+				queue.done(s)
 			}
 		})
 	}
+	wg.Wait()
 
-loop:
-	for _, s := range db.Subscriptions() {
-		select {
-		case ch <- s:
-		case <-ctx.Done():
-			break loop
+	// Nothing fetched in a dry run is committed, so ETags, seen items and
+	// schedules are left as they were for the next real run.
+	if o.DryRun {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report := newFetchReport(start, true, results, nil)
+		if o.Report != "" {
+			if err := report.write(ctx, db, o.Report); err != nil {
+				return fmt.Errorf("writing report: %w", err)
+			}
+		}
+		if o.Format == "json" {
+			return printFormatted(o.Format, report)
 		}
+		report.printText()
+		return nil
 	}
-	close(ch)
-	wg.Wait()
+
+	// The run is in the metrics however it ends from here on: a failed
+	// upload is what monitoring most needs to hear of.
+	defer func() {
+		observeRun(start, err)
+		if werr := o.writeMetrics(context.WithoutCancel(ctx), client); werr != nil {
+			if err != nil {
+				slog.Error("metrics not written", "err", werr)
+			} else {
+				err = werr
+			}
+		}
+	}()
 
 	var articles []*Item
 	for _, s := range db.Subscriptions() {
@@ -70,6 +247,7 @@ loop:
 		return articles[i].Published < articles[j].Published
 	})
 
+	uploading := time.Now()
 	if err = db.PutArticles(ctx, articles); err != nil {
 		return err
 	}
@@ -78,5 +256,18 @@ loop:
 		return err
 	}
 
-	return db.Commit(ctx)
+	observeUpload(len(articles), time.Since(uploading))
+	db.core.Metrics = metrics.state()
+	if err = db.Commit(ctx); err != nil {
+		return err
+	}
+	notify(ctx, client, articles, changed)
+
+	if o.Report != "" {
+		report := newFetchReport(start, false, results, db.packs)
+		if err := report.write(ctx, db, o.Report); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+	return nil
 }
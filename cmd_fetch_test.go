@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFetchSelected(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	later := now.Add(time.Hour).Unix()
+	subs := []*Subscription{
+		{ID: 1, Tag: "tech", URL: "https://a.example.com/feed"},
+		{ID: 2, Tag: "tech/news", URL: "https://b.example.com/rss", NextFetch: later},
+		{ID: 3, Tag: "technology", URL: "https://c.example.org/feed"},
+		{ID: 4, Paused: true},
+		{ID: 5, Tag: "news", URL: "https://a.example.com/news", NextFetch: later},
+	}
+
+	tests := []struct {
+		name string
+		cmd  FetchCmd
+		want []int
+	}{
+		{"due", FetchCmd{}, []int{1, 3}},
+		{"all", FetchCmd{All: true}, []int{1, 2, 3, 5}},
+		{"ids", FetchCmd{ID: []int{2, 4, 5}}, []int{2, 5}},
+		{"tag", FetchCmd{Tag: []string{"tech"}, All: true}, []int{1, 2}},
+		{"tag prefix", FetchCmd{Tag: []string{"tech/"}, All: true}, []int{1, 2}},
+		{"nested tag", FetchCmd{Tag: []string{"tech/news"}, All: true}, []int{2}},
+		{"tag due", FetchCmd{Tag: []string{"tech"}}, []int{1}},
+		{"url glob", FetchCmd{URL: []string{"*a.example.com/*"}, All: true}, []int{1, 5}},
+		{"url glob ?", FetchCmd{URL: []string{"https://?.example.org/feed"}}, []int{3}},
+		{"tag and url", FetchCmd{Tag: []string{"tech", "news"}, URL: []string{"*.com/*"}, All: true}, []int{1, 2, 5}},
+		{"ids and filter", FetchCmd{ID: []int{5}, Tag: []string{"technology"}}, []int{3, 5}},
+	}
+	for _, tt := range tests {
+		got, err := tt.cmd.selected(subs, now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var ids []int
+		for _, s := range got {
+			ids = append(ids, s.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%s: selected %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+
+	if _, err := (&FetchCmd{ID: []int{9}}).selected(subs, now); err == nil {
+		t.Error("unknown id not reported")
+	}
+}
+
+func TestFetchDryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, Store: dir}
+	srv := serveFeed(t, rssItems("a", "b"))
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: srv.URL})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	before, err := os.ReadFile(filepath.Join(dir, dbFileKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&FetchCmd{DryRun: true, Format: "json"}).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	after, err := os.ReadFile(filepath.Join(dir, dbFileKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("db.json changed by a dry run:\n%s\n%s", before, after)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != dbFileKey {
+			t.Errorf("dry run wrote %s", e.Name())
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"sort"
 	"strconv"
@@ -12,11 +13,12 @@ import (
 )
 
 type ImportCmd struct {
-	Path   string   `arg:""    help:"Subscriptions opml file."`
-	ID     []string `short:"i" help:"Ids to import."`
-	All    bool     `short:"a" help:"Import all."`
-	Tag    *string  `short:"g" help:"Tag to assign to imported subscriptions. Overrides OPML group tags."`
-	DryRun bool     `short:"n" help:"Dry run. List resulting subscriptions without importing."`
+	Path     string   `arg:""    help:"Subscriptions opml file."`
+	ID       []string `short:"i" help:"Ids to import."`
+	All      bool     `short:"a" help:"Import all."`
+	Tag      *string  `short:"g" help:"Tag to assign to imported subscriptions. Overrides OPML group tags."`
+	DryRun   bool     `short:"n" help:"Dry run. List resulting subscriptions without importing."`
+	Discover bool     `help:"Replace urls of web pages by the feed they advertise."`
 }
 
 func (o *ImportCmd) Run() error {
@@ -52,6 +54,11 @@ func (o *ImportCmd) Run() error {
 		}
 	}
 
+	ctx := context.Background()
+	if o.Discover {
+		discoverSubs(ctx, newSubs)
+	}
+
 	if o.DryRun {
 		w = tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
 		fmt.Fprintf(w, "\nTitle\tURL\tTag\n")
@@ -63,7 +70,6 @@ func (o *ImportCmd) Run() error {
 		return nil
 	}
 
-	ctx := context.Background()
 	db, err := NewDB(ctx, true)
 	if err != nil {
 		return err
@@ -77,6 +83,26 @@ func (o *ImportCmd) Run() error {
 	return db.Commit(ctx)
 }
 
+// discoverSubs points each subscription to the feed found at its url. On
+// failure the url is kept; when several feeds are found the first is used.
+func discoverSubs(ctx context.Context, subs []*Subscription) {
+	client := newHTTPClient()
+	for _, s := range subs {
+		candidates, err := discoverFeeds(ctx, client, s.HTTP, s.URL)
+		switch {
+		case err != nil:
+			slog.Warn("feed discovery failed, keeping url", "url", s.URL, "err", err)
+		case len(candidates) == 0:
+			slog.Warn("no feed found, keeping url", "url", s.URL)
+		default:
+			if len(candidates) > 1 {
+				slog.Warn("several feeds found, using the first", "url", s.URL, "feed", candidates[0].URL)
+			}
+			s.URL = candidates[0].URL
+		}
+	}
+}
+
 type importWalker struct {
 	w           io.Writer
 	selectedIDs []string
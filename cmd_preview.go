@@ -2,27 +2,30 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gllera/srrb/mod"
 )
 
 type PreviewCmd struct {
-	URL  *url.URL `arg:"" help:"RSS feed URL to preview."`
-	Pipe []string `short:"p" help:"Pipeline processors to apply."`
-	Addr string   `short:"a" default:"localhost:8080" env:"SRR_PREVIEW_ADDR" help:"Address to listen on."`
+	URL        *url.URL `arg:"" help:"RSS feed URL to preview."`
+	Pipe       []string `short:"p" help:"Pipeline processors to apply."`
+	DateLayout string   `help:"Go time layout of the feed dates, tried before the built-in ones."`
+	Addr       string   `short:"a" default:"localhost:8080" env:"SRR_PREVIEW_ADDR" help:"Address to listen on."`
+	HTTPFlags  `embed:""`
 }
 
 var previewTmpl = template.Must(template.New("preview").Funcs(template.FuncMap{
-	"rawHTML":  func(s string) template.HTML { return template.HTML(s) },
-	"unixTime": func(ts int64) string { return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05 UTC") },
+	"rawHTML":   func(s string) template.HTML { return template.HTML(s) },
+	"unixTime":  func(ts int64) string { return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05 UTC") },
+	"hasPrefix": strings.HasPrefix,
+	"duration":  func(secs int) string { return (time.Duration(secs) * time.Second).String() },
 }).Parse(`<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -36,11 +39,14 @@ var previewTmpl = template.Must(template.New("preview").Funcs(template.FuncMap{
   article { border-bottom: 1px solid #ccc; padding: 1em 0; }
   article:last-child { border-bottom: none; }
   .meta { color: #666; font-size: 0.85em; }
+  .artwork { max-width: 160px; float: right; margin: 0 0 8px 12px; }
   h2 { margin: 0 0 0.3em; }
   h2 a { text-decoration: none; color: #1a0dab; }
   h2 a:hover { text-decoration: underline; }
   .content { margin-top: 0.5em; line-height: 1.5; overflow-wrap: break-word; word-break: break-word; }
   .content img { max-width: 100%; height: auto; }
+  .attachment { margin-top: 0.5em; }
+  .attachment video, .attachment audio { max-width: 100%; }
   @media (prefers-color-scheme: dark) {
     body { background: #1a1a1a; color: #e0e0e0; }
     h2 a { color: #8ab4f8; }
@@ -55,8 +61,17 @@ var previewTmpl = template.Must(template.New("preview").Funcs(template.FuncMap{
 {{range .}}
 <article>
   <h2>{{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h2>
-  <div class="meta">{{unixTime .Published}}</div>
+  <div class="meta">{{unixTime .Published}}{{if .Author}} · {{.Author}}{{end}}{{range .Categories}} · {{.}}{{end}}
+  {{- with .Podcast}}{{if .Season}} · S{{.Season}}{{end}}{{if .Episode}} · E{{.Episode}}{{end}}{{if .Duration}} · {{duration .Duration}}{{end}}{{end}}</div>
+  {{with .Podcast}}{{if .Image}}<img class="artwork" src="{{.Image}}" alt="">{{end}}{{end}}
   <div class="content">{{rawHTML .Content}}</div>
+  {{range .Attachments}}
+  <div class="attachment">
+    {{if hasPrefix .Type "audio/"}}<audio controls preload="none" src="{{.URL}}"></audio>
+    {{else if hasPrefix .Type "video/"}}<video controls preload="none" src="{{.URL}}"{{if .Thumbnail}} poster="{{.Thumbnail}}"{{end}}></video>
+    {{else}}<a href="{{.URL}}">{{.URL}}</a>{{end}}
+  </div>
+  {{end}}
 </article>
 {{end}}
 {{end}}
@@ -66,59 +81,62 @@ var previewTmpl = template.Must(template.New("preview").Funcs(template.FuncMap{
 
 func (o *PreviewCmd) Run() error {
 	ctx := context.Background()
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := newHTTPClient()
 	processor := mod.New()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", o.URL.String(), nil)
+	opts, err := o.HTTPFlags.apply(nil)
+	if err != nil {
+		return err
+	}
+	req, err := newRequest(ctx, o.URL.String(), opts)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", "SRRB/"+version)
 
-	res, err := client.Do(req)
+	began := time.Now()
+	res, err := opts.client(client).Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	st := fetchStats{Status: res.StatusCode, Download: time.Since(began)}
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: %s", res.Status)
+		return &statusError{res.StatusCode, res.Status}
 	}
 
-	buf := make([]byte, globals.MaxFeedSize*(1<<10)+1)
-	n, err := io.ReadFull(res.Body, buf)
-	if err == nil {
-		return fmt.Errorf("feed bigger than %d bytes", cap(buf)-1)
-	}
-	if errors.Is(err, io.EOF) {
-		return fmt.Errorf("empty response")
-	}
-	if !errors.Is(err, io.ErrUnexpectedEOF) {
+	var raw []*mod.RawItem
+
+	body, doc := readFeed(res)
+	doc.DateLayout = o.DateLayout
+	dates := itemDates{doc: doc, now: time.Now()}
+	began = time.Now()
+	err = parseFeed(body, doc, func(i *mod.RawItem) error {
+		raw = append(raw, i)
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	st.Parse = time.Since(began)
+	st.Bytes, st.Parsed = body.n, len(raw)
+	res.Body.Close()
 
 	var articles []*Item
-
-	err = parseFeed(buf[:n], func(i *mod.RawItem) error {
+	began = time.Now()
+	for _, i := range raw {
 		if err := processItem(ctx, processor, o.Pipe, i); err != nil {
 			return err
 		}
-
-		articles = append(articles, &Item{
-			Title:     i.Title,
-			Content:   i.Content,
-			Link:      i.Link,
-			Published: i.Published.Unix(),
-		})
-		return nil
-	})
-	if err != nil {
-		return err
+		articles = append(articles, newItem(nil, i, dates, len(articles)))
 	}
+	st.Pipeline = time.Since(began)
+	observeFetch(st, nil)
 
 	fmt.Printf("Serving %d articles at http://%s\n", len(articles), o.Addr)
 
 	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := previewTmpl.Execute(w, articles); err != nil {
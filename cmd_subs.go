@@ -4,9 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
 	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -28,11 +32,46 @@ func printFormatted(format string, v any) error {
 }
 
 type AddCmd struct {
-	Upd     *int      `          optional:"" help:"Update existing subscription id instead."`
-	Title   *string   `short:"t" optional:"" help:"Subscription title."`
-	URL     *url.URL  `short:"u" optional:"" help:"Subscription RSS url."`
-	Tag     *string   `short:"g" optional:"" help:"Subscription tag. Empty (\"\") to clear."`
-	Parsers *[]string `short:"p" optional:"" help:"Subscription parsers commands. Empty (\"\") for default."`
+	Upd        *int           `          optional:"" help:"Update existing subscription id instead."`
+	Title      *string        `short:"t" optional:"" help:"Subscription title. Defaults to the feed's own title."`
+	URL        *url.URL       `short:"u" optional:"" help:"Subscription RSS url."`
+	Tag        *string        `short:"g" optional:"" help:"Subscription tag. Empty (\"\") to clear."`
+	Parsers    *[]string      `short:"p" optional:"" help:"Subscription parsers commands. Empty (\"\") for default."`
+	DateLayout *string        `          optional:"" help:"Go time layout of the feed dates, tried before the built-in ones. Empty (\"\") to clear."`
+	Interval   *time.Duration `          optional:"" help:"Min time between fetches of this subscription, over the global one. 0 to clear."`
+	Pause      *bool          `          optional:"" negatable:"" help:"Pause fetching the subscription. --no-pause resumes it and resets its failures."`
+	Pick       int            `help:"Feed to subscribe to when the url offers several (1-based)."`
+	NoDiscover bool           `help:"Store the url as given instead of discovering the feeds of a web page."`
+	HTTPFlags  `embed:""`
+}
+
+// discover resolves the url given to add into a feed. When the page offers
+// several feeds and none was picked, the candidates are listed instead.
+func (o *AddCmd) discover(ctx context.Context, client *http.Client, opts *HTTPOptions) (*feedCandidate, error) {
+	candidates, err := discoverFeeds(ctx, client, opts, o.URL.String())
+	if err != nil {
+		return nil, fmt.Errorf("discovering feeds: %w", err)
+	}
+
+	switch {
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("no feed found at %s, use --no-discover to add it anyway", o.URL)
+	case o.Pick > 0 && o.Pick <= len(candidates):
+		return &candidates[o.Pick-1], nil
+	case o.Pick > 0:
+		return nil, fmt.Errorf("--pick %d out of range, found %d feeds", o.Pick, len(candidates))
+	case len(candidates) == 1:
+		return &candidates[0], nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "Pick\tTitle\tType\tURL\n")
+	fmt.Fprintf(w, "----\t-----\t----\t---\n")
+	for i, c := range candidates {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, c.Title, c.Type, c.URL)
+	}
+	w.Flush()
+	return nil, fmt.Errorf("found %d feeds at %s, choose one with --pick", len(candidates), o.URL)
 }
 
 func (o *AddCmd) Run() error {
@@ -57,14 +96,55 @@ func (o *AddCmd) Run() error {
 		if sub == nil {
 			return fmt.Errorf("subscription id %d not found", *o.Upd)
 		}
-	} else {
-		if o.Title == nil {
-			return fmt.Errorf("title is required for new subscription")
+	}
+
+	var current *HTTPOptions
+	if sub != nil {
+		current = sub.HTTP
+	}
+	opts, err := o.HTTPFlags.apply(current)
+	if err != nil {
+		return err
+	}
+
+	client := newHTTPClient()
+
+	var feedURL string
+	var info *FeedInfo
+	if o.URL != nil {
+		if o.URL.String() == "" {
+			return fmt.Errorf("url cannot be empty")
+		}
+		feedURL = o.URL.String()
+		if !o.NoDiscover {
+			c, err := o.discover(ctx, client, opts)
+			if err != nil {
+				return err
+			}
+			if c.URL != feedURL {
+				fmt.Printf("Using feed %s\n", c.URL)
+			}
+			feedURL, info = c.URL, c.Info
 		}
+	}
+
+	if sub == nil {
 		if o.URL == nil {
 			return fmt.Errorf("url is required for new subscription")
 		}
 		sub = &Subscription{}
+		if o.Title == nil {
+			if info == nil {
+				if info, err = fetchFeedInfo(ctx, client, opts, feedURL); err != nil {
+					return fmt.Errorf("discovering feed title: %w", err)
+				}
+			}
+			if info.Title == "" {
+				return fmt.Errorf("feed has no title, use -t to set one")
+			}
+			sub.Title = info.Title
+			sub.Feed = info
+		}
 		db.AddSubscription(sub)
 	}
 
@@ -75,14 +155,28 @@ func (o *AddCmd) Run() error {
 		sub.Title = *o.Title
 	}
 	if o.URL != nil {
-		if o.URL.String() == "" {
-			return fmt.Errorf("url cannot be empty")
-		}
-		sub.URL = o.URL.String()
+		sub.URL = feedURL
 	}
 	if o.Tag != nil {
 		sub.Tag = *o.Tag
 	}
+	sub.HTTP = opts
+	if o.DateLayout != nil {
+		sub.DateLayout = *o.DateLayout
+	}
+	if o.Interval != nil {
+		if *o.Interval < 0 {
+			return fmt.Errorf("interval cannot be negative")
+		}
+		sub.MinInterval = int(*o.Interval / time.Second)
+	}
+	if o.Pause != nil {
+		if *o.Pause {
+			sub.Paused = true
+		} else {
+			sub.resume()
+		}
+	}
 	if o.Parsers != nil {
 		sub.Pipeline = []string{}
 		for _, p := range *o.Parsers {
@@ -115,8 +209,17 @@ func (o *RmCmd) Run() error {
 }
 
 type LsCmd struct {
-	Tag    *string `short:"g" optional:"" help:"Filter by tag."`
-	Format string  `short:"f" default:"yaml" enum:"yaml,json" help:"Output format."`
+	Tag       *string `short:"g" optional:"" help:"Filter by tag."`
+	Unhealthy bool    `help:"Only list failing and paused subscriptions."`
+	Format    string  `short:"f" default:"yaml" enum:"yaml,json" help:"Output format."`
+}
+
+// unixTime converts a stored timestamp for display, nil when unset.
+func unixTime(ts int64) *time.Time {
+	if ts == 0 {
+		return nil
+	}
+	return new(time.Unix(ts, 0).UTC())
 }
 
 func (o *LsCmd) Run() error {
@@ -128,11 +231,17 @@ func (o *LsCmd) Run() error {
 	defer db.Close(ctx)
 
 	type SubscriptionLS struct {
-		ID    int    `json:"id"`
-		Title string `json:"title"`
-		URL   string `json:"url"`
-		Tag   string `json:"tag,omitempty" yaml:"tag,omitempty"`
-		Error string `json:"error,omitempty" yaml:"error,omitempty"`
+		ID    int       `json:"id"`
+		Title string    `json:"title"`
+		URL   string    `json:"url"`
+		Tag   string    `json:"tag,omitempty" yaml:"tag,omitempty"`
+		Feed  *FeedInfo `json:"feed,omitempty" yaml:"feed,omitempty"`
+		Error string    `json:"error,omitempty" yaml:"error,omitempty"`
+
+		Health       string     `json:"health"`
+		Failures     int        `json:"failures,omitempty" yaml:"failures,omitempty"`
+		FailingSince *time.Time `json:"failing_since,omitempty" yaml:"failing_since,omitempty"`
+		LastSuccess  *time.Time `json:"last_success,omitempty" yaml:"last_success,omitempty"`
 	}
 
 	subsList := make([]*SubscriptionLS, 0, len(db.Subscriptions()))
@@ -140,12 +249,21 @@ func (o *LsCmd) Run() error {
 		if o.Tag != nil && s.Tag != *o.Tag {
 			continue
 		}
+		if o.Unhealthy && s.health() == healthOK {
+			continue
+		}
 		subsList = append(subsList, &SubscriptionLS{
 			Title: s.Title,
 			URL:   s.URL,
 			ID:    s.ID,
 			Tag:   s.Tag,
+			Feed:  s.Feed,
 			Error: s.FetchError,
+
+			Health:       s.health(),
+			Failures:     s.Failures,
+			FailingSince: unixTime(s.FailingSince),
+			LastSuccess:  unixTime(s.LastSuccess),
 		})
 	}
 
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateLayouts are tried, in order, on dates reduced by normalizeDate: no
+// weekday, English month abbreviations, single spaces and numeric zones.
+// Zone abbreviations missing from zoneOffsets are a last resort, taken at
+// offset 0 as time.Parse does.
+var dateLayouts = func() []string {
+	dates := []string{"2 Jan 2006", "2 Jan 06", "Jan 2, 2006", "Jan 2 2006", "2006-01-02", "2006/01/02", "02.01.2006"}
+	times := []string{" 15:04:05", " 15:04", " 3:04:05 PM", " 3:04 PM"}
+
+	var layouts []string
+	for _, d := range dates {
+		for _, t := range times {
+			layouts = append(layouts, d+t+" -0700", d+t)
+		}
+		layouts = append(layouts, d)
+	}
+	layouts = append(layouts, "Jan 2 15:04:05 -0700 2006", "Jan 2 15:04:05 2006")
+
+	for _, d := range dates {
+		for _, t := range times {
+			layouts = append(layouts, d+t+" MST")
+		}
+	}
+	return append(layouts, "Jan 2 15:04:05 MST 2006")
+}()
+
+// zoneOffsets resolves the zone abbreviations found in feeds, which
+// time.Parse would take as UTC unless they are the local zone.
+var zoneOffsets = map[string]string{
+	"UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000", "WET": "+0000",
+	"EST": "-0500", "EDT": "-0400", "CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600", "PST": "-0800", "PDT": "-0700",
+	"AKST": "-0900", "AKDT": "-0800", "HST": "-1000",
+	"AST": "-0400", "ADT": "-0300", "NST": "-0330", "NDT": "-0230",
+	"BST": "+0100", "WEST": "+0100", "CET": "+0100", "MET": "+0100",
+	"CEST": "+0200", "MEST": "+0200", "MEZ": "+0100", "MESZ": "+0200", "EET": "+0200", "EEST": "+0300",
+	"MSK": "+0300", "JST": "+0900", "KST": "+0900",
+	"AWST": "+0800", "ACST": "+0930", "ACDT": "+1030", "AEST": "+1000", "AEDT": "+1100",
+	"NZST": "+1200", "NZDT": "+1300",
+}
+
+// monthNames maps English, German, Spanish and French month names and
+// abbreviations, lowercased and without dots, to time.Parse's.
+var monthNames = map[string]string{}
+
+// weekdayNames holds the weekday names and abbreviations of the same
+// languages, which normalizeDate drops.
+var weekdayNames = map[string]bool{}
+
+func init() {
+	months := [][]string{
+		{"jan", "january", "januar", "jän", "jänner", "ene", "enero", "janv", "janvier"},
+		{"feb", "february", "februar", "febrero", "fév", "févr", "février", "fev", "fevr", "fevrier"},
+		{"mar", "march", "mär", "märz", "marz", "mrz", "marzo", "mars"},
+		{"apr", "april", "abr", "abril", "avr", "avril"},
+		{"may", "mai", "mayo"},
+		{"jun", "june", "juni", "junio", "juin"},
+		{"jul", "july", "juli", "julio", "juil", "juillet"},
+		{"aug", "august", "ago", "agosto", "aoû", "août", "aou", "aout"},
+		{"sep", "sept", "september", "septiembre", "set", "setiembre", "septembre"},
+		{"oct", "october", "okt", "oktober", "octubre", "octobre"},
+		{"nov", "november", "noviembre", "novembre"},
+		{"dec", "december", "dez", "dezember", "dic", "diciembre", "déc", "décembre", "decembre"},
+	}
+	for i, names := range months {
+		abbr := time.Month(i + 1).String()[:3]
+		for _, name := range names {
+			monthNames[name] = abbr
+		}
+	}
+
+	for _, name := range []string{
+		"mon", "monday", "tue", "tues", "tuesday", "wed", "wednesday", "thu", "thur", "thurs", "thursday",
+		"fri", "friday", "sat", "saturday", "sun", "sunday",
+		"mo", "montag", "di", "dienstag", "mi", "mittwoch", "do", "donnerstag", "fr", "freitag",
+		"sa", "samstag", "sonnabend", "so", "sonntag",
+		"lun", "lunes", "mar", "martes", "mié", "mie", "miércoles", "miercoles", "jue", "jueves",
+		"vie", "viernes", "sáb", "sab", "sábado", "sabado", "dom", "domingo",
+		"lundi", "mardi", "mer", "mercredi", "jeu", "jeudi", "ven", "vendredi", "sam", "samedi", "dim", "dimanche",
+	} {
+		weekdayNames[name] = true
+	}
+}
+
+// fillerWords are dropped from localized dates such as "2 de enero de 2006"
+// or "2. Januar 2006 um 15:04 Uhr".
+var fillerWords = map[string]bool{"de": true, "del": true, "à": true, "um": true, "uhr": true}
+
+var (
+	dateComment   = regexp.MustCompile(`\s*\([^)]*\)`)
+	dateISOTime   = regexp.MustCompile(`^(\d{4}-\d\d-\d\d)T`)
+	dateUTCSuffix = regexp.MustCompile(`(\d)Z$`)
+	dateOffset    = regexp.MustCompile(`(\d:\d\d(?:[.,]\d+)?)\s*(?:GMT|UTC)?\s*([+-])(\d\d):?(\d\d)?$`)
+)
+
+// normalizeDate rewrites a feed date into the shape dateLayouts expect.
+func normalizeDate(s string) string {
+	s = dateComment.ReplaceAllString(strings.TrimSpace(s), "")
+	s = dateISOTime.ReplaceAllString(s, "$1 ")
+	s = dateUTCSuffix.ReplaceAllString(s, "$1 +0000")
+	if m := dateOffset.FindStringSubmatchIndex(s); m != nil {
+		minutes := "00"
+		if m[8] >= 0 {
+			minutes = s[m[8]:m[9]]
+		}
+		s = s[:m[3]] + " " + s[m[4]:m[5]] + s[m[6]:m[7]] + minutes
+	}
+
+	fields := strings.Fields(s)
+	out := fields[:0]
+	for i, f := range fields {
+		word := strings.ToLower(strings.TrimRight(f, ".,"))
+		switch {
+		case i == 0 && len(fields) > 1 && weekdayNames[word] && monthNames[word] == "":
+			continue
+		case i == 0 && len(fields) > 1 && weekdayNames[word] && strings.HasSuffix(f, ","):
+			continue
+		case strings.HasSuffix(f, ".") && strings.Trim(word, "0123456789") == "":
+			f = word
+		case monthNames[word] != "":
+			f = monthNames[word] + strings.TrimLeft(strings.TrimPrefix(strings.ToLower(f), word), ".")
+		case word == "am" || word == "pm":
+			f = strings.ToUpper(word)
+		case fillerWords[word]:
+			continue
+		case zoneOffsets[f] != "":
+			f = zoneOffsets[f]
+		}
+		out = append(out, f)
+	}
+	return strings.Join(out, " ")
+}
+
+// parseTime parses a feed date. layout, when set, is a time.Parse layout
+// tried first on the date as given; the built-in layouts apply to the
+// normalized date. Dates without a zone are taken as UTC.
+func parseTime(s, layout string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	if layout != "" {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), true
+	}
+
+	n := normalizeDate(s)
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l, n); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	utc := func(y int, mo time.Month, d, h, mi, s int) time.Time {
+		return time.Date(y, mo, d, h, mi, s, 0, time.UTC)
+	}
+
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		// RFC 822 and its variants
+		{"Mon, 02 Jan 2006 15:04:05 -0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"Mon, 2 Jan 2006 15:04:05 +0000", utc(2006, 1, 2, 15, 4, 5)},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", utc(2006, 1, 2, 15, 4, 5)},
+		{"Mon, 02 Jan 2006 15:04:05 UT", utc(2006, 1, 2, 15, 4, 5)},
+		{"Mon, 02 Jan 2006 15:04:05 Z", utc(2006, 1, 2, 15, 4, 5)},
+		{"Tue, 10 Jun 2003 04:00:00 EST", utc(2003, 6, 10, 9, 0, 0)},
+		{"Tue, 10 Jun 2003 04:00:00 EDT", utc(2003, 6, 10, 8, 0, 0)},
+		{"Fri, 04 Aug 2023 10:30:00 PDT", utc(2023, 8, 4, 17, 30, 0)},
+		{"Fri, 04 Aug 2023 10:30:00 PST", utc(2023, 8, 4, 18, 30, 0)},
+		{"Wed, 15 Mar 2023 09:00:00 CET", utc(2023, 3, 15, 8, 0, 0)},
+		{"Wed, 15 Jun 2023 09:00:00 CEST", utc(2023, 6, 15, 7, 0, 0)},
+		{"Wed, 15 Jun 2023 09:00:00 BST", utc(2023, 6, 15, 8, 0, 0)},
+		{"Wed, 15 Jun 2023 09:00:00 +0200 (CEST)", utc(2023, 6, 15, 7, 0, 0)},
+		{"Mon, 02 Jan 06 15:04:05 -0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"Mon, 02 Jan 2006 15:04 -0700", utc(2006, 1, 2, 22, 4, 0)},
+		{"Mon, 02 Jan 2006 15:04:05", utc(2006, 1, 2, 15, 4, 5)},
+		{"02 Jan 2006 15:04:05 -0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"Monday, 02 January 2006 15:04:05 -0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"Thu, 7 Sept 2023 08:00:00 +0000", utc(2023, 9, 7, 8, 0, 0)},
+		{"Mon,  02  Jan 2006  15:04:05  -0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"Mon, 02 Jan 2006 15:04:05 GMT+0200", utc(2006, 1, 2, 13, 4, 5)},
+		// Unknown zone abbreviations are taken as UTC rather than dropped
+		{"Fri, 15 Mar 2024 14:30:00 IST", utc(2024, 3, 15, 14, 30, 0)},
+		{"Fri, 15 Mar 2024 14:30 SGT", utc(2024, 3, 15, 14, 30, 0)},
+		{"Fri Mar 15 14:30:00 HKT 2024", utc(2024, 3, 15, 14, 30, 0)},
+		{"Mon, 02 Jan 2006 15:04:05.123 +0000", utc(2006, 1, 2, 15, 4, 5).Add(123 * time.Millisecond)},
+
+		// ISO 8601 and its variants
+		{"2006-01-02T15:04:05Z", utc(2006, 1, 2, 15, 4, 5)},
+		{"2006-01-02T15:04:05+07:00", utc(2006, 1, 2, 8, 4, 5)},
+		{"2006-01-02T15:04:05.999999Z", utc(2006, 1, 2, 15, 4, 5).Add(999999 * time.Microsecond)},
+		{"2006-01-02T15:04:05-0700", utc(2006, 1, 2, 22, 4, 5)},
+		{"2006-01-02T15:04:05+02", utc(2006, 1, 2, 13, 4, 5)},
+		{"2006-01-02T15:04Z", utc(2006, 1, 2, 15, 4, 0)},
+		{"2006-01-02T15:04:05", utc(2006, 1, 2, 15, 4, 5)},
+		{"2006-01-02 15:04:05", utc(2006, 1, 2, 15, 4, 5)},
+		{"2006-01-02 15:04:05 +0100", utc(2006, 1, 2, 14, 4, 5)},
+		{"2006-01-02 15:04:05 EST", utc(2006, 1, 2, 20, 4, 5)},
+		{"2006-01-02", utc(2006, 1, 2, 0, 0, 0)},
+		{"2006/01/02 15:04", utc(2006, 1, 2, 15, 4, 0)},
+
+		// Other English forms
+		{"January 2, 2006", utc(2006, 1, 2, 0, 0, 0)},
+		{"Jan 2, 2006 3:04 PM", utc(2006, 1, 2, 15, 4, 0)},
+		{"Jan 2, 2006 3:04:05 pm EST", utc(2006, 1, 2, 20, 4, 5)},
+		{"Mon Jan  2 15:04:05 MST 2006", utc(2006, 1, 2, 22, 4, 5)},
+		{"Mon Jan 2 15:04:05 2006", utc(2006, 1, 2, 15, 4, 5)},
+
+		// German
+		{"Mo, 02 Jan 2006 15:04:05 +0100", utc(2006, 1, 2, 14, 4, 5)},
+		{"Di, 14 Mär 2023 10:00:00 +0100", utc(2023, 3, 14, 9, 0, 0)},
+		{"Do, 05 Okt 2023 12:00:00 MESZ", utc(2023, 10, 5, 10, 0, 0)},
+		{"Donnerstag, 5. Oktober 2023 12:00", utc(2023, 10, 5, 12, 0, 0)},
+		{"5. Dezember 2023 um 18:30 Uhr", utc(2023, 12, 5, 18, 30, 0)},
+		{"05.10.2023 12:00", utc(2023, 10, 5, 12, 0, 0)},
+
+		// Spanish
+		{"lun, 02 ene 2006 15:04:05 +0100", utc(2006, 1, 2, 14, 4, 5)},
+		{"mar, 14 mar 2023 10:00:00 GMT", utc(2023, 3, 14, 10, 0, 0)},
+		{"miércoles, 20 de diciembre de 2023", utc(2023, 12, 20, 0, 0, 0)},
+		{"Sáb, 01 Abr 2023 08:15:00 -0300", utc(2023, 4, 1, 11, 15, 0)},
+
+		// French
+		{"lun., 02 janv. 2006 15:04:05 +0100", utc(2006, 1, 2, 14, 4, 5)},
+		{"mer., 15 févr. 2023 09:00:00 +0100", utc(2023, 2, 15, 8, 0, 0)},
+		{"samedi 19 août 2023 à 14:30", utc(2023, 8, 19, 14, 30, 0)},
+		{"Jeu, 07 Déc 2023 07:00:00 +0100", utc(2023, 12, 7, 6, 0, 0)},
+
+		// Not dates
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+		{"32 Jan 2006", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseTime(tt.input, "")
+		if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q) = %v, %v; want %v", tt.input, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParseTimeLayout(t *testing.T) {
+	want := time.Date(2023, 10, 5, 12, 30, 0, 0, time.UTC)
+	if got, ok := parseTime("20231005 1230", "20060102 1504"); !ok || !got.Equal(want) {
+		t.Errorf("custom layout = %v, %v; want %v", got, ok, want)
+	}
+	if _, ok := parseTime("20231005 1230", ""); ok {
+		t.Error("expected failure without the custom layout")
+	}
+	if got, ok := parseTime("2023-10-05T12:30:00Z", "20060102 1504"); !ok || !got.Equal(want) {
+		t.Errorf("built-in fallback = %v, %v; want %v", got, ok, want)
+	}
+}
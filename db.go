@@ -11,6 +11,7 @@ import (
 	"slices"
 
 	"github.com/gllera/srrb/backend"
+	"github.com/gllera/srrb/mod"
 )
 
 func jsonEncode(v any) ([]byte, error) {
@@ -33,6 +34,7 @@ type DB struct {
 	backend.Backend
 	core   DBCore
 	locked bool
+	packs  []string // keys of the packs saved, for the run report
 }
 
 type DBCore struct {
@@ -45,6 +47,7 @@ type DBCore struct {
 	PackOffset     int             `json:"pack_off"`
 	FirstFetchedAt int64           `json:"first_fetched,omitempty"`
 	Subscriptions  []*Subscription `json:"subscriptions"`
+	Metrics        *metricsState   `json:"metrics,omitempty"`
 	oTotalArticles int
 	oFetchedAt     int64
 }
@@ -126,11 +129,50 @@ func (o *DB) RemoveSubscription(id int) {
 }
 
 type Item struct {
-	Sub       *Subscription
-	Title     string
-	Content   string
-	Link      string
-	Published int64
+	Sub         *Subscription
+	Title       string
+	Content     string
+	Link        string
+	Published   int64
+	Author      string
+	Categories  []string
+	Attachments []mod.Attachment
+	Podcast     *mod.Podcast
+
+	// DateSource tells how Published was made up when the feed gave no
+	// usable date: "feed", "fetch" or "future" (see itemDates.set).
+	DateSource    string
+	OrigPublished int64
+}
+
+// itemMeta is the optional eighth idx column: a JSON object with the
+// article fields that have no column of their own. Lines without extra
+// metadata keep the original seven columns.
+type itemMeta struct {
+	Author      string           `json:"author,omitempty"`
+	Categories  []string         `json:"cat,omitempty"`
+	Attachments []mod.Attachment `json:"att,omitempty"`
+	Podcast     *mod.Podcast     `json:"pod,omitempty"`
+	DateSource  string           `json:"date,omitempty"`
+	OrigPub     int64            `json:"pub,omitempty"`
+}
+
+func (i *Item) meta() (string, error) {
+	data, err := jsonEncode(&itemMeta{
+		Author:      i.Author,
+		Categories:  i.Categories,
+		Attachments: i.Attachments,
+		Podcast:     i.Podcast,
+		DateSource:  i.DateSource,
+		OrigPub:     i.OrigPublished,
+	})
+	if err != nil {
+		return "", err
+	}
+	if s := string(bytes.TrimSpace(data)); s != "{}" {
+		return s, nil
+	}
+	return "", nil
 }
 
 type pack struct {
@@ -187,6 +229,7 @@ func (o *DB) savePack(ctx context.Context, key string, p *pack) error {
 	if err := o.Put(ctx, key, p.buf.Bytes(), true); err != nil {
 		return err
 	}
+	o.packs = append(o.packs, key)
 	p.buf.Reset()
 	p.gz.Reset(&p.buf)
 	return nil
@@ -288,7 +331,13 @@ func (o *DB) PutArticles(ctx context.Context, articles []*Item) error {
 			c.PackOffset = 0
 		}
 
-		meta.writeTSV(c.FetchedAt, c.NextPackID, c.PackOffset, item.Sub.ID, item.Published, item.Title, item.Link)
+		fields := []any{c.FetchedAt, c.NextPackID, c.PackOffset, item.Sub.ID, item.Published, item.Title, item.Link}
+		if m, err := item.meta(); err != nil {
+			return err
+		} else if m != "" {
+			fields = append(fields, m)
+		}
+		meta.writeTSV(fields...)
 		data.writeEntry(item.Content)
 
 		item.Sub.TotalArticles++
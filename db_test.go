@@ -10,9 +10,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/gllera/srrb/mod"
 )
 
 var ctx = context.Background()
@@ -69,8 +72,15 @@ func readArticles(t *testing.T, dir string, metaPath string) []*Item {
 	scanner := bufio.NewScanner(bytes.NewReader(metaBytes))
 	for scanner.Scan() {
 		fields := strings.Split(scanner.Text(), "\t")
-		if len(fields) != 7 {
-			t.Fatalf("expected 7 TSV fields, got %d: %q", len(fields), scanner.Text())
+		if len(fields) != 7 && len(fields) != 8 {
+			t.Fatalf("expected 7 or 8 TSV fields, got %d: %q", len(fields), scanner.Text())
+		}
+
+		var meta itemMeta
+		if len(fields) == 8 {
+			if err := json.Unmarshal([]byte(fields[7]), &meta); err != nil {
+				t.Fatalf("decoding meta column %q: %v", fields[7], err)
+			}
 		}
 
 		// fields[0] is fetched-time, skip
@@ -113,11 +123,15 @@ func readArticles(t *testing.T, dir string, metaPath string) []*Item {
 		}
 
 		articles = append(articles, &Item{
-			Sub:       &Subscription{ID: subID},
-			Title:     title,
-			Content:   content,
-			Link:      link,
-			Published: published,
+			Sub:         &Subscription{ID: subID},
+			Title:       title,
+			Content:     content,
+			Link:        link,
+			Published:   published,
+			Author:      meta.Author,
+			Categories:  meta.Categories,
+			Attachments: meta.Attachments,
+			Podcast:     meta.Podcast,
 		})
 	}
 	return articles
@@ -156,6 +170,43 @@ func TestPutArticlesBasic(t *testing.T) {
 	}
 }
 
+func TestPutArticlesMeta(t *testing.T) {
+	db, c, dir := setupTestDB(t)
+	sub1 := &Subscription{ID: 1}
+	c.Subscriptions = []*Subscription{sub1}
+
+	att := []mod.Attachment{{URL: "http://example.com/a.mp3", Type: "audio/mpeg", Length: 10, Duration: 60}}
+	pod := &mod.Podcast{Image: "http://example.com/ep.jpg", Duration: 60, Season: 2, Episode: 7}
+	articles := []*Item{
+		{Sub: sub1, Title: "Plain", Published: 1000},
+		{Sub: sub1, Title: "Podcast", Published: 2000, Attachments: att, Podcast: pod},
+		{Sub: sub1, Title: "Byline", Published: 3000, Author: "Ann", Categories: []string{"go", "rss"}},
+	}
+
+	if err := db.PutArticles(ctx, articles); err != nil {
+		t.Fatalf("PutArticles: %v", err)
+	}
+
+	lines := readTsLines(t, filepath.Join(dir, fmt.Sprintf("idx/%v.gz", c.DataToggle)))
+	if len(lines[0]) != 7 {
+		t.Errorf("line without metadata has %d fields, want 7", len(lines[0]))
+	}
+	if len(lines[1]) != 8 {
+		t.Fatalf("line with metadata has %d fields, want 8", len(lines[1]))
+	}
+
+	result := readAllArticles(t, dir, c.DataToggle)
+	if !slices.Equal(result[1].Attachments, att) {
+		t.Errorf("attachments = %+v, want %+v", result[1].Attachments, att)
+	}
+	if p := result[1].Podcast; p == nil || p.Image != pod.Image || p.Season != pod.Season || p.Episode != pod.Episode {
+		t.Errorf("podcast = %+v, want %+v", p, pod)
+	}
+	if result[2].Author != "Ann" || !slices.Equal(result[2].Categories, []string{"go", "rss"}) {
+		t.Errorf("author = %q, categories = %q", result[2].Author, result[2].Categories)
+	}
+}
+
 func TestPutArticlesEmpty(t *testing.T) {
 	db, _, _ := setupTestDB(t)
 
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/gllera/srrb/mod"
+)
+
+// feedCandidate is a feed found by discoverFeeds. Info is set when the
+// feed itself was downloaded during discovery.
+type feedCandidate struct {
+	URL   string
+	Title string
+	Type  string
+	Info  *FeedInfo
+}
+
+var feedMediaTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/rdf+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// commonFeedPaths are probed, relative to the page, when a page does not
+// advertise any feed.
+var commonFeedPaths = []string{"feed", "rss.xml", "atom.xml", "feed.xml", "index.xml", "feed.json", "rss"}
+
+// fetchDocument downloads url and returns its whole body, which discovery
+// parses both as a feed and as an HTML page.
+func fetchDocument(ctx context.Context, client *http.Client, opts *HTTPOptions, url string) ([]byte, *feedDoc, error) {
+	req, err := newRequest(ctx, url, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := opts.client(client).Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, &statusError{res.StatusCode, res.Status}
+	}
+	body, doc := readFeed(res)
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, doc, nil
+}
+
+// feedInfo parses the header of a feed document.
+func feedInfo(data []byte, doc *feedDoc) (*FeedInfo, error) {
+	err := parseFeed(bytes.NewReader(data), doc, func(*mod.RawItem) error {
+		return ErrStopFeed
+	})
+	if err != nil {
+		return nil, err
+	}
+	doc.Info.Title = cleanTitle(doc.Info.Title)
+	return &doc.Info, nil
+}
+
+// fetchFeedInfo downloads a feed and returns its header metadata.
+func fetchFeedInfo(ctx context.Context, client *http.Client, opts *HTTPOptions, url string) (*FeedInfo, error) {
+	data, doc, err := fetchDocument(ctx, client, opts, url)
+	if err != nil {
+		return nil, err
+	}
+	return feedInfo(data, doc)
+}
+
+// discoverFeeds returns the feeds offered by the page at pageURL. A feed
+// URL is its own single candidate. HTML pages yield their <link
+// rel="alternate"> feeds or, when they advertise none, those of the common
+// feed locations that actually serve a feed.
+func discoverFeeds(ctx context.Context, client *http.Client, opts *HTTPOptions, pageURL string) ([]feedCandidate, error) {
+	data, doc, err := fetchDocument(ctx, client, opts, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := feedInfo(data, doc); err == nil {
+		return []feedCandidate{{URL: pageURL, Title: info.Title, Info: info}}, nil
+	}
+
+	candidates := htmlFeedLinks(data, doc.URL)
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+
+	var seen []string
+	for _, path := range commonFeedPaths {
+		u := resolveURL(parseURL(doc.URL), path)
+		data, doc, err := fetchDocument(ctx, client, opts, u)
+		if err != nil {
+			slog.Debug("probing feed location", "url", u, "err", err)
+			continue
+		}
+		if slices.Contains(seen, doc.URL) {
+			continue
+		}
+		info, err := feedInfo(data, doc)
+		if err != nil {
+			slog.Debug("probing feed location", "url", u, "err", err)
+			continue
+		}
+		seen = append(seen, doc.URL)
+		candidates = append(candidates, feedCandidate{URL: u, Title: info.Title, Info: info})
+	}
+	return candidates, nil
+}
+
+// htmlFeedLinks returns the feeds advertised by an HTML page through
+// <link rel="alternate"> elements, resolved against the page URL or its
+// <base href>.
+func htmlFeedLinks(data []byte, pageURL string) []feedCandidate {
+	base := parseURL(pageURL)
+	var links []feedCandidate
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "base":
+				if href := htmlAttr(tok, "href"); href != "" {
+					base = resolveBase(base, href)
+				}
+			case "link":
+				rel := strings.Fields(strings.ToLower(htmlAttr(tok, "rel")))
+				mt, _, _ := mime.ParseMediaType(htmlAttr(tok, "type"))
+				href := htmlAttr(tok, "href")
+				if href == "" || !slices.Contains(rel, "alternate") || !slices.Contains(feedMediaTypes, mt) {
+					continue
+				}
+				u := resolveURL(base, href)
+				if slices.ContainsFunc(links, func(c feedCandidate) bool { return c.URL == u }) {
+					continue
+				}
+				links = append(links, feedCandidate{URL: u, Title: htmlAttr(tok, "title"), Type: mt})
+			case "body":
+				return links
+			}
+		}
+	}
+}
+
+func htmlAttr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
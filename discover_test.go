@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const discoverFeed = `<rss version="2.0"><channel><title>Site Feed</title></channel></rss>`
+
+func discoverServer(t *testing.T, routes map[string]string) *httptest.Server {
+	t.Helper()
+	globals = &Globals{MaxFeedSize: 100}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscoverFeedURL(t *testing.T) {
+	srv := discoverServer(t, map[string]string{"/rss": discoverFeed})
+
+	got, err := discoverFeeds(context.Background(), newHTTPClient(), nil, srv.URL+"/rss")
+	if err != nil {
+		t.Fatalf("discoverFeeds: %v", err)
+	}
+	if len(got) != 1 || got[0].URL != srv.URL+"/rss" {
+		t.Fatalf("candidates = %+v, want the feed itself", got)
+	}
+	if got[0].Info == nil || got[0].Info.Title != "Site Feed" {
+		t.Errorf("info = %+v, want feed header", got[0].Info)
+	}
+}
+
+func TestDiscoverHTMLLinks(t *testing.T) {
+	srv := discoverServer(t, map[string]string{"/blog/": `<!DOCTYPE html>
+<html><head>
+<meta charset="utf-8">
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="Posts" href="posts.xml">
+<link rel="Alternate" type="application/atom+xml" title="Atom" href="/atom.xml">
+<link rel="alternate" type="application/feed+json" href="/feed.json">
+<link rel="alternate" type="application/rss+xml" href="posts.xml">
+<link rel="alternate" hreflang="de" href="/de/">
+</head><body>
+<link rel="alternate" type="application/rss+xml" href="/ignored.xml">
+</body></html>`})
+
+	got, err := discoverFeeds(context.Background(), newHTTPClient(), nil, srv.URL+"/blog/")
+	if err != nil {
+		t.Fatalf("discoverFeeds: %v", err)
+	}
+
+	want := []feedCandidate{
+		{URL: srv.URL + "/blog/posts.xml", Title: "Posts", Type: "application/rss+xml"},
+		{URL: srv.URL + "/atom.xml", Title: "Atom", Type: "application/atom+xml"},
+		{URL: srv.URL + "/feed.json", Type: "application/feed+json"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("candidates = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiscoverCommonPaths(t *testing.T) {
+	srv := discoverServer(t, map[string]string{
+		"/":        `<html><head><title>No feeds here</title></head></html>`,
+		"/rss.xml": discoverFeed,
+		"/feed":    `<html>not a feed</html>`,
+	})
+
+	got, err := discoverFeeds(context.Background(), newHTTPClient(), nil, srv.URL+"/")
+	if err != nil {
+		t.Fatalf("discoverFeeds: %v", err)
+	}
+	if len(got) != 1 || got[0].URL != srv.URL+"/rss.xml" {
+		t.Fatalf("candidates = %+v, want probed /rss.xml", got)
+	}
+	if got[0].Title != "Site Feed" {
+		t.Errorf("title = %q", got[0].Title)
+	}
+}
+
+func TestDiscoverNothing(t *testing.T) {
+	srv := discoverServer(t, map[string]string{"/": `<html><body>Nothing</body></html>`})
+
+	got, err := discoverFeeds(context.Background(), newHTTPClient(), nil, srv.URL+"/")
+	if err != nil {
+		t.Fatalf("discoverFeeds: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("candidates = %+v, want none", got)
+	}
+}
+
+func TestDiscoverHTTPError(t *testing.T) {
+	srv := discoverServer(t, nil)
+
+	if _, err := discoverFeeds(context.Background(), newHTTPClient(), nil, srv.URL+"/missing"); err == nil {
+		t.Error("expected error for 404 page")
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"html"
 	"io"
+	"log/slog"
+	"mime"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/net/html/charset"
+
 	"github.com/gllera/srrb/mod"
 )
 
@@ -21,6 +33,8 @@ func hash(s string) uint32 {
 	return h.Sum32()
 }
 
+// rawField is one element of the raw item tree handed to modules. Child
+// elements and attributes are keyed by rawKey.
 type rawField struct {
 	Txt  string            `json:"@,omitempty"`
 	Attr map[string]string `json:"$,omitempty"`
@@ -40,17 +54,75 @@ func (r rawFeedItem) text(names ...string) string {
 	return ""
 }
 
-var dateFields = []string{"pubDate", "published", "issued", "date", "created", "updated", "modified"}
+// html returns the first non-empty named field as HTML. Atom content and
+// summary are plain text unless their type says otherwise, so those are
+// escaped, and so is itunes:summary unless it holds markup; any other field
+// is taken to hold HTML already.
+var htmlMarkup = regexp.MustCompile(`</?[a-zA-Z][^<>]*>|&(?:[a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
 
-var dateFormats = []string{
-	time.RFC1123Z, time.RFC1123, time.RFC3339,
-	"Mon, 2 Jan 2006 15:04:05 -0700",
-	"Mon, 2 Jan 2006 15:04:05 MST",
-	"2 Jan 2006 15:04:05 -0700",
-	"2006-01-02T15:04:05-07:00",
-	"2006-01-02",
+func (r rawFeedItem) html(names ...string) string {
+	for _, name := range names {
+		for _, f := range r[name] {
+			if f.Txt == "" {
+				continue
+			}
+			if name == "itunes:summary" && !htmlMarkup.MatchString(f.Txt) {
+				return html.EscapeString(f.Txt)
+			}
+			if name != "content" && name != "summary" {
+				return f.Txt
+			}
+			switch t, _, _ := mime.ParseMediaType(f.Attr["type"]); t {
+			case "html", "xhtml", "text/html":
+				return f.Txt
+			case "", "text", "text/plain":
+				return html.EscapeString(f.Txt)
+			}
+		}
+	}
+	return ""
+}
+
+// namespaces maps well-known namespace URIs to the prefix used for them in
+// raw item keys, whatever prefix the document itself declares.
+var namespaces = map[string]string{
+	"http://www.w3.org/2005/Atom":                     "atom",
+	"http://purl.org/rss/1.0/modules/content/":        "content",
+	"http://purl.org/dc/elements/1.1/":                "dc",
+	"http://purl.org/dc/terms/":                       "dcterms",
+	"http://search.yahoo.com/mrss/":                   "media",
+	"http://search.yahoo.com/mrss":                    "media",
+	"http://www.itunes.com/dtds/podcast-1.0.dtd":      "itunes",
+	"https://podcastindex.org/namespace/1.0":          "podcast",
+	"http://www.google.com/schemas/play-podcasts/1.0": "googleplay",
+	"http://purl.org/rss/1.0/modules/syndication/":    "sy",
+	"http://purl.org/rss/1.0/modules/slash/":          "slash",
+	"http://wellformedweb.org/CommentAPI/":            "wfw",
+	"http://purl.org/syndication/thread/1.0":          "thr",
+	"http://www.georss.org/georss":                    "georss",
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#":     "rdf",
+	"http://www.w3.org/XML/1998/namespace":            "xml",
+}
+
+// rawKey returns the key of an element or attribute in the raw item tree.
+// Names in the item's own namespace keep their local name, well-known
+// namespaces use their conventional prefix ("media:content") and any other
+// namespace is spelled out as "{uri}local". Undeclared prefixes, which the
+// decoder leaves unresolved, are kept as written.
+func rawKey(name xml.Name, native string) string {
+	switch {
+	case name.Space == "" || name.Space == native:
+		return name.Local
+	case namespaces[name.Space] != "":
+		return namespaces[name.Space] + ":" + name.Local
+	case !strings.Contains(name.Space, ":"):
+		return name.Space + ":" + name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
 }
 
+var dateFields = []string{"pubDate", "published", "issued", "dc:date", "created", "dcterms:created", "updated", "modified", "dcterms:modified"}
+
 func parseLink(r rawFeedItem) string {
 	var fallback string
 	for _, f := range r["link"] {
@@ -68,62 +140,507 @@ func parseLink(r rawFeedItem) string {
 	return fallback
 }
 
-func parseDate(r rawFeedItem) time.Time {
-	for _, key := range dateFields {
+// feedDateFields are the header elements dating a feed as a whole.
+var feedDateFields = []string{"lastBuildDate", "pubDate", "updated", "dc:date", "modified", "dcterms:modified"}
+
+// parseDate returns the first of the named fields holding a valid date, or
+// the zero time if none does. layout is the subscription's own date layout,
+// if any (see parseTime).
+func parseDate(r rawFeedItem, layout string, fields ...string) time.Time {
+	for _, key := range fields {
 		for _, f := range r[key] {
-			for _, layout := range dateFormats {
-				if t, err := time.Parse(layout, f.Txt); err == nil {
-					return t.UTC()
-				}
+			if t, ok := parseTime(f.Txt, layout); ok {
+				return t
 			}
 		}
 	}
-	return time.Now().UTC()
+	return time.Time{}
+}
+
+// addAttachment appends a to list, merging it into an existing entry for
+// the same URL. Feeds often repeat an <enclosure> as <media:content>.
+func addAttachment(list []mod.Attachment, a mod.Attachment) []mod.Attachment {
+	if a.URL == "" {
+		return list
+	}
+	for i := range list {
+		if e := &list[i]; e.URL == a.URL {
+			e.Type = cmp.Or(e.Type, a.Type)
+			e.Length = cmp.Or(e.Length, a.Length)
+			e.Duration = cmp.Or(e.Duration, a.Duration)
+			e.Thumbnail = cmp.Or(e.Thumbnail, a.Thumbnail)
+			return list
+		}
+	}
+	return append(list, a)
+}
+
+func parseInt(s string) int64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return int64(f)
+}
+
+func mediaThumbnail(r rawFeedItem) string {
+	for _, f := range r["media:thumbnail"] {
+		if url := f.Attr["url"]; url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+// mediaContents extracts <media:content> elements of r and of its
+// <media:group> children. thumb is the thumbnail of the enclosing element.
+func mediaContents(list []mod.Attachment, r rawFeedItem, thumb string) []mod.Attachment {
+	thumb = cmp.Or(mediaThumbnail(r), thumb)
+	for _, f := range r["media:content"] {
+		list = addAttachment(list, mod.Attachment{
+			URL:       f.Attr["url"],
+			Type:      f.Attr["type"],
+			Length:    parseInt(f.Attr["fileSize"]),
+			Duration:  int(parseInt(f.Attr["duration"])),
+			Thumbnail: cmp.Or(mediaThumbnail(f.Chld), thumb),
+		})
+	}
+	for _, g := range r["media:group"] {
+		list = mediaContents(list, g.Chld, thumb)
+	}
+	return list
+}
+
+// parseDuration reads an itunes:duration, given in seconds or as
+// [[hh:]mm:]ss.
+func parseDuration(s string) int {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	var secs int
+	for _, p := range parts {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		secs = secs*60 + int(n)
+	}
+	return secs
 }
 
-func rawToFeedItem(r rawFeedItem) *mod.RawItem {
+func itunesImage(r rawFeedItem) string {
+	for _, f := range r["itunes:image"] {
+		if href := cmp.Or(f.Attr["href"], f.Txt); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// parsePodcast extracts the episode metadata of a podcast item, or nil if
+// it has none. Episodes without artwork of their own get showImage, the
+// channel's, which alone does not make an item an episode.
+func parsePodcast(r rawFeedItem, showImage string) *mod.Podcast {
+	p := &mod.Podcast{
+		Summary:     r.html("itunes:summary"),
+		Image:       itunesImage(r),
+		Duration:    parseDuration(r.text("itunes:duration")),
+		Season:      int(parseInt(r.text("itunes:season", "podcast:season"))),
+		Episode:     int(parseInt(r.text("itunes:episode", "podcast:episode"))),
+		EpisodeType: strings.ToLower(r.text("itunes:episodeType")),
+	}
+	switch strings.ToLower(r.text("itunes:explicit")) {
+	case "yes", "true", "explicit":
+		p.Explicit = true
+	}
+	for _, f := range r["podcast:transcript"] {
+		if f.Attr["url"] != "" {
+			p.Transcripts = append(p.Transcripts, mod.Transcript{
+				URL:      f.Attr["url"],
+				Type:     f.Attr["type"],
+				Language: f.Attr["language"],
+			})
+		}
+	}
+
+	if p.Summary == "" && p.Image == "" && p.Duration == 0 && p.Season == 0 && p.Episode == 0 &&
+		p.EpisodeType == "" && !p.Explicit && len(p.Transcripts) == 0 {
+		return nil
+	}
+	p.Image = cmp.Or(p.Image, showImage)
+	return p
+}
+
+func parseAttachments(r rawFeedItem) []mod.Attachment {
+	var list []mod.Attachment
+	for _, f := range r["enclosure"] {
+		list = addAttachment(list, mod.Attachment{
+			URL:    f.Attr["url"],
+			Type:   f.Attr["type"],
+			Length: parseInt(f.Attr["length"]),
+		})
+	}
+	for _, f := range r["link"] {
+		if f.Attr["rel"] == "enclosure" {
+			list = addAttachment(list, mod.Attachment{
+				URL:    f.Attr["href"],
+				Type:   f.Attr["type"],
+				Length: parseInt(f.Attr["length"]),
+			})
+		}
+	}
+	list = mediaContents(list, r, "")
+
+	if thumb := cmp.Or(mediaThumbnail(r), itunesImage(r)); thumb != "" {
+		for i := range list {
+			list[i].Thumbnail = cmp.Or(list[i].Thumbnail, thumb)
+		}
+	}
+	// The episode duration describes the enclosure when it is the only one.
+	if len(list) == 1 && list[0].Duration == 0 {
+		list[0].Duration = parseDuration(r.text("itunes:duration"))
+	}
+	return list
+}
+
+// rssAuthor extracts the name from an RSS 2.0 author, which is specified
+// as an email address optionally followed by the name in parentheses.
+func rssAuthor(s string) string {
+	if i := strings.IndexByte(s, '('); i > 0 && strings.HasSuffix(s, ")") {
+		return strings.TrimSpace(s[i+1 : len(s)-1])
+	}
+	return s
+}
+
+func parseAuthor(r rawFeedItem) string {
+	var names []string
+	for _, f := range r["author"] {
+		if f.Chld != nil {
+			names = append(names, f.Chld.text("name", "email"))
+		} else {
+			names = append(names, rssAuthor(f.Txt))
+		}
+	}
+	if len(names) == 0 {
+		for _, key := range []string{"dc:creator", "itunes:author"} {
+			for _, f := range r[key] {
+				names = append(names, f.Txt)
+			}
+			if len(names) > 0 {
+				break
+			}
+		}
+	}
+	names = slices.DeleteFunc(names, func(s string) bool { return s == "" })
+	return strings.Join(names, ", ")
+}
+
+func parseCategories(r rawFeedItem) []string {
+	var list []string
+	for _, f := range r["category"] {
+		list = append(list, cmp.Or(f.Attr["label"], f.Attr["term"], f.Txt))
+	}
+	for _, f := range r["dc:subject"] {
+		list = append(list, f.Txt)
+	}
+	return uniqueStrings(list)
+}
+
+// uniqueStrings trims the strings of list and drops empty and repeated
+// ones, keeping the original order.
+func uniqueStrings(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// itemKey returns the duplicate detection key of an item identified by id,
+// its guid or link. Items with neither are told apart by title and content.
+func itemKey(id, title, content string) uint64 {
+	if id == "" {
+		id = title + "\x00" + content
+	}
+	return hash64(id)
+}
+
+// rawToFeedItem converts item r of a feed whose header, as read so far, is
+// header.
+func rawToFeedItem(r, header rawFeedItem, dateLayout string) *mod.RawItem {
 	link := parseLink(r)
-	published := parseDate(r)
+	var published *time.Time
+	if t := parseDate(r, dateLayout, dateFields...); !t.IsZero() {
+		published = &t
+	}
 
 	guid := r.text("guid", "id")
 	if guid == "" {
 		guid = link
 	}
+	title := r.text("title", "itunes:title")
+	content := r.html("content:encoded", "content", "description", "itunes:summary", "summary")
 
 	return &mod.RawItem{
-		GUID:      hash(guid),
-		Title:     r.text("title"),
-		Content:   r.text("content", "encoded", "description", "summary"),
-		Link:      link,
-		Published: &published,
-		Raw:       &r,
+		GUID:        hash(guid),
+		Key:         itemKey(guid, title, content),
+		Title:       title,
+		Content:     content,
+		Link:        link,
+		Published:   published,
+		Author:      parseAuthor(r),
+		Categories:  parseCategories(r),
+		Attachments: parseAttachments(r),
+		Podcast:     parsePodcast(r, itunesImage(header)),
+		Raw:         &r,
 	}
 }
 
-// parseFeed streams feed items to the callback. If the callback returns
-// ErrStopFeed, parsing stops without error. Any other error is propagated.
-func parseFeed(data []byte, fn func(*mod.RawItem) error) error {
-	dec := xml.NewDecoder(bytes.NewReader(data))
+// feedSniffLen is how much of a document isJSONFeed gets to look at.
+const feedSniffLen = 512
 
-	var itemTag string
+// isJSONFeed reports whether a document is a JSON Feed, judging by its
+// content type or, failing that, by its first non-blank byte.
+func isJSONFeed(data []byte, contentType string) bool {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mt {
+		case "application/feed+json", "application/json":
+			return true
+		}
+	}
+	data = bytes.TrimLeft(data, " \t\r\n\ufeff")
+	return len(data) > 0 && data[0] == '{'
+}
+
+// FeedInfo is what a feed tells about itself in its channel header.
+type FeedInfo struct {
+	Title       string `json:"title,omitempty"       yaml:"title,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Link        string `json:"link,omitempty"        yaml:"link,omitempty"`
+	Language    string `json:"language,omitempty"    yaml:"language,omitempty"`
+	Icon        string `json:"icon,omitempty"        yaml:"icon,omitempty"`
+}
+
+// favicon returns the conventional favicon location of a site.
+func favicon(site string) string {
+	u := parseURL(site)
+	if u == nil || !u.IsAbs() || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/favicon.ico"
+}
+
+// siteLink returns the channel link pointing to the site itself, ignoring
+// Atom self, hub and other related links.
+func siteLink(r rawFeedItem) string {
+	for _, f := range r["link"] {
+		if href := f.Attr["href"]; href == "" {
+			if f.Txt != "" {
+				return f.Txt
+			}
+		} else if rel := f.Attr["rel"]; rel == "" || rel == "alternate" {
+			return href
+		}
+	}
+	return ""
+}
+
+func headerIcon(r rawFeedItem) string {
+	if icon := r.text("icon"); icon != "" {
+		return icon
+	}
+	for _, f := range r["image"] {
+		if u := cmp.Or(f.Chld.text("url"), f.Attr["rdf:resource"]); u != "" {
+			return u
+		}
+	}
+	for _, f := range r["itunes:image"] {
+		if href := f.Attr["href"]; href != "" {
+			return href
+		}
+	}
+	return r.text("logo")
+}
+
+// headerInfo extracts the feed metadata from the container header. lang is
+// the xml:lang of the document, used when the header declares no language.
+func headerInfo(r rawFeedItem, base *url.URL, lang string) FeedInfo {
+	info := FeedInfo{
+		Title:       r.text("title", "dc:title"),
+		Description: r.text("description", "subtitle", "tagline", "itunes:summary", "dc:description"),
+		Link:        resolveURL(base, siteLink(r)),
+		Language:    cmp.Or(r.text("language", "dc:language"), lang),
+		Icon:        resolveURL(base, headerIcon(r)),
+	}
+	if info.Icon == "" {
+		info.Icon = favicon(info.Link)
+	}
+	return info
+}
+
+// feedDoc describes a downloaded feed document. URL is where it was
+// fetched from and serves as last resort base for relative URLs. DateLayout
+// is the subscription's date layout for feeds parseTime cannot read. Info,
+// Date, the feed's own last update, and TTL, the update interval it
+// announces, are filled in by parseFeed as the feed header is read, so they
+// are complete for the items that follow the header.
+type feedDoc struct {
+	URL         string
+	ContentType string
+	DateLayout  string
+	Info        FeedInfo
+	Date        time.Time
+	TTL         time.Duration
+}
+
+// parseFeed streams feed items to the callback as they are read from r. If
+// the callback returns ErrStopFeed, parsing stops without error. Any other
+// error is propagated. Relative item URLs are resolved before the callback
+// sees the item.
+//
+// XML feeds are parsed strictly first. If that fails with a syntax error,
+// typically HTML entities or unclosed tags, the document is parsed again
+// in lenient mode and the items already delivered are skipped. The part of
+// the document read so far is kept for that second pass.
+func parseFeed(r io.Reader, doc *feedDoc, fn func(*mod.RawItem) error) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(feedSniffLen)
+	if len(head) == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("empty document")
+		}
+		return err
+	}
+	if isJSONFeed(head, doc.ContentType) {
+		// json.Decoder does not skip a byte order mark.
+		if bytes.HasPrefix(head, []byte("\ufeff")) {
+			br.Discard(len("\ufeff"))
+		}
+		return parseJSONFeed(br, doc, fn)
+	}
+
+	var read bytes.Buffer
+	var emitted int
+	var fnFailed bool
+	err = parseXML(newXMLDecoder(io.TeeReader(br, &read), doc.ContentType, true), doc, func(i *mod.RawItem) error {
+		emitted++
+		err := fn(i)
+		fnFailed = err != nil && !errors.Is(err, ErrStopFeed)
+		return err
+	})
+
+	var syntaxErr *xml.SyntaxError
+	if fnFailed || !errors.As(err, &syntaxErr) {
+		if err == nil {
+			slog.Debug("parsed feed", "mode", "strict")
+		}
+		return err
+	}
+	slog.Debug("strict parsing failed, retrying in lenient mode", "err", err)
+
+	skip := emitted
+	rest := io.MultiReader(&read, br)
+	err = parseXML(newXMLDecoder(rest, doc.ContentType, false), doc, func(i *mod.RawItem) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		return fn(i)
+	})
+	if err == nil {
+		slog.Debug("parsed feed", "mode", "lenient")
+	}
+	return err
+}
+
+// newXMLDecoder returns a decoder that transcodes r to UTF-8. A charset
+// given in contentType takes precedence over the XML declaration, except
+// for UTF-8, which servers often send by default regardless of the actual
+// encoding. Non-strict decoders accept HTML entities and unclosed tags.
+func newXMLDecoder(r io.Reader, contentType string, strict bool) *xml.Decoder {
+	charsetReader := charset.NewReaderLabel
+
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if label := params["charset"]; label != "" && !strings.EqualFold(label, "utf-8") {
+			if cr, err := charset.NewReaderLabel(label, r); err == nil {
+				r = cr
+				charsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+					return input, nil
+				}
+			} else {
+				slog.Debug("ignoring content type charset", "charset", label, "err", err)
+			}
+		}
+	}
+
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charsetReader
+	if !strict {
+		dec.Strict = false
+		dec.AutoClose = xml.HTMLAutoClose
+		dec.Entity = xml.HTMLEntity
+	}
+	return dec
+}
+
+func xmlAttr(attrs []xml.Attr, name string) (string, bool) {
+	for _, a := range attrs {
+		if a.Name.Space == "http://www.w3.org/XML/1998/namespace" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// xmlBase returns the xml:base attribute of an element, if any.
+func xmlBase(attrs []xml.Attr) (string, bool) {
+	return xmlAttr(attrs, "base")
+}
+
+func xmlLang(attrs []xml.Attr) string {
+	lang, _ := xmlAttr(attrs, "lang")
+	return lang
+}
+
+// parseXML streams the items of an XML feed. Elements of the container,
+// <feed> for Atom and <channel> for RSS, are collected into a header that
+// provides the channel link used as base URL for relative item URLs. An
+// xml:base in scope takes precedence over the channel link.
+func parseXML(dec *xml.Decoder, doc *feedDoc, fn func(*mod.RawItem) error) error {
+	var root xml.StartElement
 	for {
 		tok, err := dec.Token()
 		if err != nil {
 			return fmt.Errorf("detecting feed format: %w", err)
 		}
 		if se, ok := tok.(xml.StartElement); ok {
-			switch se.Name.Local {
-			case "rss", "RDF":
-				itemTag = "item"
-			case "feed":
-				itemTag = "entry"
-			default:
-				return fmt.Errorf("unsupported feed format: <%s>", se.Name.Local)
-			}
+			root = se
 			break
 		}
 	}
 
+	var itemTag string
+	switch root.Name.Local {
+	case "rss", "RDF":
+		itemTag = "item"
+	case "feed":
+		itemTag = "entry"
+	default:
+		return fmt.Errorf("unsupported feed format: <%s>", root.Name.Local)
+	}
+
+	base := parseURL(doc.URL)
+	explicitBase := false
+	if v, ok := xmlBase(root.Attr); ok {
+		base, explicitBase = resolveBase(base, v), true
+	}
+	itemBase := base
+
+	header := make(rawFeedItem)
+	inContainer := itemTag == "entry"
+	native := root.Name.Space
+	lang := xmlLang(root.Attr)
+
 	for {
 		tok, err := dec.Token()
 		if err == io.EOF {
@@ -132,29 +649,87 @@ func parseFeed(data []byte, fn func(*mod.RawItem) error) error {
 		if err != nil {
 			return fmt.Errorf("parsing feed: %w", err)
 		}
-		se, ok := tok.(xml.StartElement)
-		if !ok || se.Name.Local != itemTag {
+
+		var se xml.StartElement
+		switch t := tok.(type) {
+		case xml.StartElement:
+			se = t
+		case xml.EndElement:
+			if t.Name.Local == "channel" {
+				inContainer = false
+			}
+			continue
+		default:
 			continue
 		}
-		raw, err := parseElement(dec, se)
-		if err != nil {
-			return err
-		}
-		if err := fn(rawToFeedItem(raw.Chld)); errors.Is(err, ErrStopFeed) {
-			return nil
-		} else if err != nil {
-			return err
+
+		switch {
+		case se.Name.Local == itemTag:
+			raw, err := parseElement(dec, se, se.Name.Space)
+			if err != nil {
+				return err
+			}
+			b := itemBase
+			if v, ok := raw.Attr["xml:base"]; ok {
+				b = resolveBase(b, v)
+			}
+			item := rawToFeedItem(raw.Chld, header, doc.DateLayout)
+			resolveItemURLs(item, b)
+			if err := fn(item); errors.Is(err, ErrStopFeed) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+		case se.Name.Local == "channel" && !inContainer:
+			inContainer = true
+			native = se.Name.Space
+			if v, ok := xmlBase(se.Attr); ok {
+				base, explicitBase = resolveBase(base, v), true
+				itemBase = base
+			}
+
+		case inContainer:
+			f, err := parseElement(dec, se, native)
+			if err != nil {
+				return err
+			}
+			key := rawKey(se.Name, native)
+			header[key] = append(header[key], f)
+			if key == "link" && !explicitBase {
+				if link := siteLink(header); link != "" {
+					itemBase = resolveBase(base, link)
+				}
+			}
+			doc.Info = headerInfo(header, itemBase, lang)
+			doc.Date = parseDate(header, doc.DateLayout, feedDateFields...)
+			doc.TTL = feedTTL(header)
+
+		default:
+			if err := dec.Skip(); err != nil {
+				return fmt.Errorf("parsing feed: %w", err)
+			}
 		}
 	}
 }
 
-func parseElement(dec *xml.Decoder, start xml.StartElement) (rawField, error) {
+// parseElement reads start's subtree into a rawField. Names in the native
+// namespace are keyed by their local name, see rawKey.
+func parseElement(dec *xml.Decoder, start xml.StartElement, native string) (rawField, error) {
 	var f rawField
-	if len(start.Attr) > 0 {
-		f.Attr = make(map[string]string, len(start.Attr))
-		for _, a := range start.Attr {
-			f.Attr[a.Name.Local] = a.Value
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+			continue
 		}
+		if f.Attr == nil {
+			f.Attr = make(map[string]string, len(start.Attr))
+		}
+		f.Attr[rawKey(a.Name, "")] = a.Value
+	}
+	if f.Attr["type"] == "xhtml" {
+		var err error
+		f.Txt, err = parseXHTML(dec, start)
+		return f, err
 	}
 
 	for {
@@ -169,14 +744,237 @@ func parseElement(dec *xml.Decoder, start xml.StartElement) (rawField, error) {
 			f.Txt = strings.TrimSpace(f.Txt)
 			return f, nil
 		case xml.StartElement:
-			child, err := parseElement(dec, t)
+			child, err := parseElement(dec, t, native)
 			if err != nil {
 				return f, err
 			}
 			if f.Chld == nil {
 				f.Chld = make(rawFeedItem)
 			}
-			f.Chld[t.Name.Local] = append(f.Chld[t.Name.Local], child)
+			key := rawKey(t.Name, native)
+			f.Chld[key] = append(f.Chld[key], child)
+		}
+	}
+}
+
+// voidElements are the HTML elements that take no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// parseXHTML serializes the inline XHTML of an Atom text construct back
+// into HTML, dropping the wrapping div the format requires. Namespaced
+// attributes, comments and processing instructions are left out.
+func parseXHTML(dec *xml.Decoder, start xml.StartElement) (string, error) {
+	var b strings.Builder
+	depth, wrapper := 0, -1
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("parsing <%s>: %w", start.Name.Local, err)
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.WriteString(html.EscapeString(string(t)))
+		case xml.StartElement:
+			depth++
+			if wrapper < 0 && depth == 1 && t.Name.Local == "div" && strings.TrimSpace(b.String()) == "" {
+				wrapper = depth
+				b.Reset()
+				continue
+			}
+			b.WriteString("<" + t.Name.Local)
+			for _, a := range t.Attr {
+				if a.Name.Space == "" && a.Name.Local != "xmlns" {
+					b.WriteString(" " + a.Name.Local + `="` + html.EscapeString(a.Value) + `"`)
+				}
+			}
+			b.WriteString(">")
+		case xml.EndElement:
+			if depth == 0 {
+				return strings.TrimSpace(b.String()), nil
+			}
+			if depth == wrapper {
+				wrapper = 0
+			} else if !voidElements[t.Name.Local] {
+				b.WriteString("</" + t.Name.Local + ">")
+			}
+			depth--
+		}
+	}
+}
+
+type jsonFeedAttachment struct {
+	URL      string  `json:"url"`
+	MimeType string  `json:"mime_type"`
+	Size     int64   `json:"size_in_bytes"`
+	Duration float64 `json:"duration_in_seconds"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            json.RawMessage      `json:"id"`
+	URL           string               `json:"url"`
+	ExternalURL   string               `json:"external_url"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	ContentText   string               `json:"content_text"`
+	Summary       string               `json:"summary"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Image         string               `json:"image"`
+	Author        *jsonFeedAuthor      `json:"author"`
+	Authors       []jsonFeedAuthor     `json:"authors"`
+	Tags          []string             `json:"tags"`
+	Attachments   []jsonFeedAttachment `json:"attachments"`
+}
+
+type jsonFeed struct {
+	Version     string            `json:"version"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	HomePageURL string            `json:"home_page_url"`
+	Icon        string            `json:"icon"`
+	Favicon     string            `json:"favicon"`
+	Language    string            `json:"language"`
+	Items       []json.RawMessage `json:"items"`
+}
+
+// id returns the item id as a string. JSON Feed requires a string, but
+// numeric ids are common in the wild.
+func (i *jsonFeedItem) id() string {
+	var s string
+	if err := json.Unmarshal(i.ID, &s); err == nil {
+		return s
+	}
+	var n json.Number
+	if err := json.Unmarshal(i.ID, &n); err == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// author joins the JSON Feed 1.1 authors, falling back to the 1.0 author.
+func (i *jsonFeedItem) author() string {
+	authors := i.Authors
+	if len(authors) == 0 && i.Author != nil {
+		authors = []jsonFeedAuthor{*i.Author}
+	}
+	var names []string
+	for _, a := range authors {
+		if a.Name != "" {
+			names = append(names, a.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (i *jsonFeedItem) content() string {
+	switch {
+	case i.ContentHTML != "":
+		return i.ContentHTML
+	case i.ContentText != "":
+		return html.EscapeString(i.ContentText)
+	}
+	return html.EscapeString(i.Summary)
+}
+
+func (i *jsonFeedItem) published(layout string) *time.Time {
+	for _, d := range []string{i.DatePublished, i.DateModified} {
+		if t, ok := parseTime(d, layout); ok {
+			return &t
+		}
+	}
+	return nil
+}
+
+func jsonFeedToFeedItem(data json.RawMessage, dateLayout string) (*mod.RawItem, error) {
+	var i jsonFeedItem
+	if err := json.Unmarshal(data, &i); err != nil {
+		return nil, fmt.Errorf("parsing JSON feed item: %w", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing JSON feed item: %w", err)
+	}
+
+	link := i.URL
+	if link == "" {
+		link = i.ExternalURL
+	}
+	guid := i.id()
+	if guid == "" {
+		guid = link
+	}
+	content := i.content()
+	published := i.published(dateLayout)
+
+	var attachments []mod.Attachment
+	for _, a := range i.Attachments {
+		attachments = addAttachment(attachments, mod.Attachment{
+			URL:       a.URL,
+			Type:      a.MimeType,
+			Length:    a.Size,
+			Duration:  int(a.Duration),
+			Thumbnail: i.Image,
+		})
+	}
+
+	return &mod.RawItem{
+		GUID:        hash(guid),
+		Key:         itemKey(guid, i.Title, content),
+		Title:       i.Title,
+		Content:     content,
+		Link:        link,
+		Published:   published,
+		Author:      i.author(),
+		Categories:  uniqueStrings(i.Tags),
+		Attachments: attachments,
+		Raw:         raw,
+	}, nil
+}
+
+func parseJSONFeed(r io.Reader, doc *feedDoc, fn func(*mod.RawItem) error) error {
+	var feed jsonFeed
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return fmt.Errorf("parsing JSON feed: %w", err)
+	}
+	if !strings.Contains(feed.Version, "jsonfeed.org/version/") {
+		return fmt.Errorf("unsupported JSON feed version: %q", feed.Version)
+	}
+
+	base := parseURL(doc.URL)
+	doc.Info = FeedInfo{
+		Title:       feed.Title,
+		Description: feed.Description,
+		Link:        resolveURL(base, feed.HomePageURL),
+		Language:    feed.Language,
+		Icon:        resolveURL(base, cmp.Or(feed.Favicon, feed.Icon)),
+	}
+	if doc.Info.Icon == "" {
+		doc.Info.Icon = favicon(doc.Info.Link)
+	}
+	if feed.HomePageURL != "" {
+		base = resolveBase(base, feed.HomePageURL)
+	}
+
+	for _, data := range feed.Items {
+		item, err := jsonFeedToFeedItem(data, doc.DateLayout)
+		if err != nil {
+			return err
+		}
+		resolveItemURLs(item, base)
+		if err := fn(item); errors.Is(err, ErrStopFeed) {
+			return nil
+		} else if err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,9 +12,19 @@ import (
 )
 
 func collectFeed(t *testing.T, data string) []*mod.RawItem {
+	t.Helper()
+	return collectFeedType(t, data, "")
+}
+
+func collectFeedType(t *testing.T, data, contentType string) []*mod.RawItem {
+	t.Helper()
+	return collectFeedDoc(t, data, &feedDoc{ContentType: contentType})
+}
+
+func collectFeedDoc(t *testing.T, data string, doc *feedDoc) []*mod.RawItem {
 	t.Helper()
 	var items []*mod.RawItem
-	err := parseFeed([]byte(data), func(item *mod.RawItem) error {
+	err := parseFeed(strings.NewReader(data), doc, func(item *mod.RawItem) error {
 		items = append(items, item)
 		return nil
 	})
@@ -124,6 +137,115 @@ func TestParseRDF(t *testing.T) {
 	}
 }
 
+const jsonFeedFixture = `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "JSON Blog",
+  "items": [
+    {
+      "id": "https://example.com/posts/2",
+      "url": "https://example.com/posts/2",
+      "title": "Second",
+      "content_html": "<p>HTML body</p>",
+      "date_published": "2024-06-15T10:30:00+02:00",
+      "authors": [{"name": "Jane"}],
+      "attachments": [{"url": "https://example.com/a.mp3", "mime_type": "audio/mpeg"}]
+    },
+    {
+      "id": 1,
+      "external_url": "https://other.example.com/x",
+      "title": "First",
+      "content_text": "Plain <text>"
+    }
+  ]
+}`
+
+func TestParseJSONFeed(t *testing.T) {
+	items := collectFeedType(t, jsonFeedFixture, "application/feed+json; charset=utf-8")
+
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+
+	first := items[0]
+	if first.GUID != hash("https://example.com/posts/2") {
+		t.Errorf("guid = %d, want hash of id", first.GUID)
+	}
+	if first.Title != "Second" {
+		t.Errorf("title = %q", first.Title)
+	}
+	if first.Link != "https://example.com/posts/2" {
+		t.Errorf("link = %q", first.Link)
+	}
+	if first.Content != "<p>HTML body</p>" {
+		t.Errorf("content = %q", first.Content)
+	}
+	if want := time.Date(2024, 6, 15, 8, 30, 0, 0, time.UTC); !first.Published.Equal(want) {
+		t.Errorf("published = %v, want %v", first.Published, want)
+	}
+	raw, ok := first.Raw.(map[string]any)
+	if !ok {
+		t.Fatalf("raw = %T, want original JSON object", first.Raw)
+	}
+	if _, ok := raw["attachments"]; !ok {
+		t.Error("raw should keep attachments")
+	}
+	if first.Author != "Jane" {
+		t.Errorf("author = %q, want %q", first.Author, "Jane")
+	}
+
+	second := items[1]
+	if second.GUID != hash("1") {
+		t.Errorf("numeric id should hash as its string form")
+	}
+	if second.Link != "https://other.example.com/x" {
+		t.Errorf("link = %q, want external_url fallback", second.Link)
+	}
+	if second.Content != "Plain &lt;text&gt;" {
+		t.Errorf("content = %q, want escaped content_text", second.Content)
+	}
+}
+
+func TestParseJSONFeedSniffed(t *testing.T) {
+	items := collectFeedType(t, "\n  "+jsonFeedFixture, "text/plain")
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+}
+
+func TestParseJSONFeedBOM(t *testing.T) {
+	for _, contentType := range []string{"application/feed+json", "text/plain"} {
+		items := collectFeedType(t, "\ufeff"+jsonFeedFixture, contentType)
+		if len(items) != 2 {
+			t.Fatalf("%s: got %d items, want 2", contentType, len(items))
+		}
+	}
+}
+
+func TestParseJSONFeedStop(t *testing.T) {
+	count := 0
+	err := parseFeed(strings.NewReader(jsonFeedFixture), &feedDoc{}, func(*mod.RawItem) error {
+		count++
+		return ErrStopFeed
+	})
+	if err != nil {
+		t.Fatalf("parseFeed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("callback called %d times, want 1", count)
+	}
+}
+
+func TestParseJSONFeedInvalid(t *testing.T) {
+	for _, data := range []string{`{"items": [`, `{"version": "1", "items": []}`} {
+		err := parseFeed(strings.NewReader(data), &feedDoc{ContentType: "application/feed+json"}, func(*mod.RawItem) error {
+			return nil
+		})
+		if err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
 func TestParseDescriptionFallback(t *testing.T) {
 	items := collectFeed(t, `<rss version="2.0"><channel>
     <item>
@@ -150,13 +272,33 @@ func TestParseGUIDFallbackToLink(t *testing.T) {
 	}
 }
 
-func TestParseDateFallbackToNow(t *testing.T) {
-	items := collectFeed(t, `<rss version="2.0"><channel>
+func TestParseDateLayout(t *testing.T) {
+	items := collectFeedDoc(t, `<rss version="2.0"><channel>
+    <item><title>A</title><pubDate>05/10/2023</pubDate></item>
+  </channel></rss>`, &feedDoc{DateLayout: "02/01/2006"})
+
+	if items[0].Published == nil || items[0].Published.Month() != time.October {
+		t.Errorf("published = %v, want 5 October 2023", items[0].Published)
+	}
+}
+
+func TestParseUndated(t *testing.T) {
+	doc := &feedDoc{}
+	items := collectFeedDoc(t, `<rss version="2.0"><channel>
+    <lastBuildDate>Mon, 02 Jan 2006 15:04:05 -0700</lastBuildDate>
     <item><title>No Date</title></item>
-  </channel></rss>`)
+  </channel></rss>`, doc)
 
-	if time.Since(*items[0].Published) > time.Second {
-		t.Errorf("published = %v, expected ~now", items[0].Published)
+	if items[0].Published != nil {
+		t.Errorf("published = %v, want nil", items[0].Published)
+	}
+	if want := time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC); !doc.Date.Equal(want) {
+		t.Errorf("feed date = %v, want %v", doc.Date, want)
+	}
+
+	items = collectFeed(t, `{"version": "https://jsonfeed.org/version/1.1", "items": [{"id": "1"}]}`)
+	if items[0].Published != nil {
+		t.Errorf("JSON feed published = %v, want nil", items[0].Published)
 	}
 }
 
@@ -178,11 +320,11 @@ func TestParseCDATA(t *testing.T) {
 
 func TestParseStopFeed(t *testing.T) {
 	count := 0
-	err := parseFeed([]byte(`<rss version="2.0"><channel>
+	err := parseFeed(strings.NewReader(`<rss version="2.0"><channel>
     <item><title>A</title></item>
     <item><title>B</title></item>
     <item><title>C</title></item>
-  </channel></rss>`), func(item *mod.RawItem) error {
+  </channel></rss>`), &feedDoc{}, func(item *mod.RawItem) error {
 		count++
 		if count == 2 {
 			return ErrStopFeed
@@ -199,7 +341,7 @@ func TestParseStopFeed(t *testing.T) {
 }
 
 func TestParseUnsupportedFormat(t *testing.T) {
-	err := parseFeed([]byte(`<html><body>Not a feed</body></html>`), func(*mod.RawItem) error {
+	err := parseFeed(strings.NewReader(`<html><body>Not a feed</body></html>`), &feedDoc{}, func(*mod.RawItem) error {
 		t.Fatal("callback should not be called")
 		return nil
 	})
@@ -209,7 +351,7 @@ func TestParseUnsupportedFormat(t *testing.T) {
 }
 
 func TestParseInvalidXML(t *testing.T) {
-	err := parseFeed([]byte(`not xml at all`), func(*mod.RawItem) error {
+	err := parseFeed(strings.NewReader(`not xml at all`), &feedDoc{}, func(*mod.RawItem) error {
 		return nil
 	})
 	if err == nil {
@@ -258,9 +400,9 @@ func TestParseEmptyFeed(t *testing.T) {
 
 func TestParseCallbackError(t *testing.T) {
 	testErr := fmt.Errorf("custom callback error")
-	err := parseFeed([]byte(`<rss version="2.0"><channel>
+	err := parseFeed(strings.NewReader(`<rss version="2.0"><channel>
     <item><title>A</title></item>
-  </channel></rss>`), func(*mod.RawItem) error {
+  </channel></rss>`), &feedDoc{}, func(*mod.RawItem) error {
 		return testErr
 	})
 
@@ -386,6 +528,137 @@ func TestParseAtomSummaryFallback(t *testing.T) {
 	}
 }
 
+func TestParseAtomContentTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text", `<content type="text">a &lt; b &amp; &lt;i&gt;c&lt;/i&gt;</content>`, "a &lt; b &amp; &lt;i&gt;c&lt;/i&gt;"},
+		{"default text", `<content>1 &lt; 2</content>`, "1 &lt; 2"},
+		{"html", `<content type="html">&lt;p&gt;a &amp;amp; b&lt;/p&gt;</content>`, "<p>a &amp; b</p>"},
+		{"html cdata", `<content type="html"><![CDATA[<p>x</p>]]></content>`, "<p>x</p>"},
+		{"mime html", `<content type="text/html">&lt;b&gt;x&lt;/b&gt;</content>`, "<b>x</b>"},
+		{"xhtml", `<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Hello <b>world</b> &amp; co</p></div></content>`,
+			"<p>Hello <b>world</b> &amp; co</p>"},
+		{"xhtml prefixed", `<content type="xhtml"><xhtml:div xmlns:xhtml="http://www.w3.org/1999/xhtml"><xhtml:p>Hi</xhtml:p></xhtml:div></content>`,
+			"<p>Hi</p>"},
+		{"xhtml void and attrs", `<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">a<br/><img src="x.png" alt="&quot;q&quot;"/><a href="/p?a=1&amp;b=2" xml:lang="en">l</a></div></content>`,
+			`a<br><img src="http://example.com/x.png" alt="&#34;q&#34;"><a href="http://example.com/p?a=1&amp;b=2">l</a>`},
+		{"xhtml summary", `<summary type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><em>s</em></div></summary>`, "<em>s</em>"},
+		{"unknown type", `<content type="application/octet-stream">AAAA</content><summary>fallback</summary>`, "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := collectFeed(t, `<feed xmlns="http://www.w3.org/2005/Atom" xml:base="http://example.com/">
+  <entry><title>E</title>`+tt.content+`</entry>
+</feed>`)
+			if items[0].Content != tt.want {
+				t.Errorf("content = %q, want %q", items[0].Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAtomXHTMLTitle(t *testing.T) {
+	items := collectFeed(t, `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <title type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">A <i>big</i> day</div></title>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Body</p></div></content>
+  </entry>
+</feed>`)
+
+	if items[0].Title != "A <i>big</i> day" {
+		t.Errorf("title = %q, want serialized markup", items[0].Title)
+	}
+	raw := *items[0].Raw.(*rawFeedItem)
+	if raw["content"][0].Txt != "<p>Body</p>" || raw["content"][0].Chld != nil {
+		t.Errorf("raw content = %+v, want markup in text", raw["content"][0])
+	}
+}
+
+func TestParsePodcast(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0"
+     xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"
+     xmlns:podcast="https://podcastindex.org/namespace/1.0">
+  <channel>
+    <link>http://example.com/show/</link>
+    <itunes:image href="http://example.com/show.jpg"/>
+    <item>
+      <itunes:title>Episode Seven</itunes:title>
+      <itunes:summary>&lt;p&gt;Show notes&lt;/p&gt;</itunes:summary>
+      <enclosure url="ep7.mp3" type="audio/mpeg" length="1234"/>
+      <itunes:image href="ep7.jpg"/>
+      <itunes:duration>1:02:03</itunes:duration>
+      <itunes:season>2</itunes:season>
+      <itunes:episode>7</itunes:episode>
+      <itunes:episodeType>Full</itunes:episodeType>
+      <itunes:explicit>true</itunes:explicit>
+      <podcast:transcript url="ep7.vtt" type="text/vtt" language="en"/>
+      <podcast:transcript type="text/html"/>
+    </item>
+    <item>
+      <title>Plain</title>
+      <description>&lt;p&gt;Desc&lt;/p&gt;</description>
+      <itunes:summary>Q&amp;A</itunes:summary>
+    </item>
+    <item>
+      <title>Post</title>
+      <description>Text</description>
+    </item>
+  </channel>
+</rss>`)
+
+	item := items[0]
+	if item.Title != "Episode Seven" {
+		t.Errorf("title = %q, want itunes:title fallback", item.Title)
+	}
+	if item.Content != "<p>Show notes</p>" {
+		t.Errorf("content = %q, want itunes:summary markup", item.Content)
+	}
+	want := mod.Podcast{
+		Summary:     "<p>Show notes</p>",
+		Image:       "http://example.com/show/ep7.jpg",
+		Duration:    3723,
+		Season:      2,
+		Episode:     7,
+		EpisodeType: "full",
+		Explicit:    true,
+		Transcripts: []mod.Transcript{{URL: "http://example.com/show/ep7.vtt", Type: "text/vtt", Language: "en"}},
+	}
+	if p := item.Podcast; p == nil || p.Summary != want.Summary || p.Image != want.Image || p.Duration != want.Duration ||
+		p.Season != want.Season || p.Episode != want.Episode || p.EpisodeType != want.EpisodeType ||
+		p.Explicit != want.Explicit || !slices.Equal(p.Transcripts, want.Transcripts) {
+		t.Errorf("podcast = %+v, want %+v", item.Podcast, want)
+	}
+	att := mod.Attachment{URL: "http://example.com/show/ep7.mp3", Type: "audio/mpeg", Length: 1234, Duration: 3723, Thumbnail: "http://example.com/show/ep7.jpg"}
+	if !slices.Equal(item.Attachments, []mod.Attachment{att}) {
+		t.Errorf("attachments = %+v, want %+v", item.Attachments, att)
+	}
+
+	if p := items[1].Podcast; p == nil || p.Image != "http://example.com/show.jpg" || p.Summary != "Q&amp;A" {
+		t.Errorf("podcast = %+v, want show image and escaped summary", p)
+	}
+	if items[1].Content != "<p>Desc</p>" {
+		t.Errorf("content = %q, want description before itunes:summary", items[1].Content)
+	}
+	if items[2].Podcast != nil {
+		t.Errorf("podcast = %+v, want nil for a plain item", items[2].Podcast)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]int{
+		"3600": 3600, "90.5": 90, "05:30": 330, "1:02:03": 3723, "": 0, "1:2:3:4": 0, "abc": 0, "-5": 0,
+	}
+	for in, want := range tests {
+		if got := parseDuration(in); got != want {
+			t.Errorf("parseDuration(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
 func TestParseMultipleItems(t *testing.T) {
 	items := collectFeed(t, `<rss version="2.0"><channel>
     <item><title>A</title></item>
@@ -419,7 +692,7 @@ func TestParseRSSWithAttributes(t *testing.T) {
 }
 
 func TestParseEmptyXML(t *testing.T) {
-	err := parseFeed([]byte(""), func(*mod.RawItem) error {
+	err := parseFeed(strings.NewReader(""), &feedDoc{}, func(*mod.RawItem) error {
 		return nil
 	})
 	if err == nil {
@@ -427,6 +700,444 @@ func TestParseEmptyXML(t *testing.T) {
 	}
 }
 
+func TestParseNamespacedRSS(t *testing.T) {
+	items := collectFeed(t, `<?xml version="1.0"?>
+<rss version="2.0"
+     xmlns:content="http://purl.org/rss/1.0/modules/content/"
+     xmlns:mrss="http://search.yahoo.com/mrss/"
+     xmlns:dc="http://purl.org/dc/elements/1.1/"
+     xmlns:atom="http://www.w3.org/2005/Atom"
+     xmlns:x="urn:example:ext">
+  <channel>
+    <item>
+      <mrss:title>Media title</mrss:title>
+      <title>Item title</title>
+      <atom:link rel="self" href="http://example.com/self"/>
+      <link>http://example.com/1</link>
+      <mrss:content url="http://example.com/v.mp4" type="video/mp4"/>
+      <description>Desc</description>
+      <content:encoded><![CDATA[<p>Full</p>]]></content:encoded>
+      <dc:date>2024-01-02</dc:date>
+      <x:rating>5</x:rating>
+    </item>
+  </channel>
+</rss>`)
+
+	item := items[0]
+	if item.Title != "Item title" {
+		t.Errorf("title = %q, want RSS title over media:title", item.Title)
+	}
+	if item.Link != "http://example.com/1" {
+		t.Errorf("link = %q, want RSS link over atom:link", item.Link)
+	}
+	if item.Content != "<p>Full</p>" {
+		t.Errorf("content = %q, want content:encoded", item.Content)
+	}
+	if item.Published.Year() != 2024 {
+		t.Errorf("published = %v, want dc:date", item.Published)
+	}
+
+	raw := *item.Raw.(*rawFeedItem)
+	for _, key := range []string{"title", "media:title", "link", "atom:link", "media:content", "description", "content:encoded", "dc:date", "{urn:example:ext}rating"} {
+		if len(raw[key]) != 1 {
+			t.Errorf("raw[%q] has %d fields, want 1", key, len(raw[key]))
+		}
+	}
+	if got := raw["media:content"][0].Attr["url"]; got != "http://example.com/v.mp4" {
+		t.Errorf("media:content url = %q", got)
+	}
+}
+
+func TestParseNamespacedAtom(t *testing.T) {
+	items := collectFeed(t, `<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <entry xml:lang="en">
+    <media:group>
+      <media:title>Video</media:title>
+      <media:content url="http://example.com/v.mp4"/>
+    </media:group>
+    <title>Entry</title>
+    <media:content url="http://example.com/a.mp3"/>
+    <content type="html">&lt;p&gt;Body&lt;/p&gt;</content>
+  </entry>
+</feed>`)
+
+	item := items[0]
+	if item.Title != "Entry" {
+		t.Errorf("title = %q", item.Title)
+	}
+	if item.Content != "<p>Body</p>" {
+		t.Errorf("content = %q, want atom content", item.Content)
+	}
+
+	raw := *item.Raw.(*rawFeedItem)
+	if len(raw["content"]) != 1 || len(raw["media:content"]) != 1 {
+		t.Errorf("content and media:content should be kept apart: %v", raw)
+	}
+	if got := raw["media:group"][0].Chld.text("media:title"); got != "Video" {
+		t.Errorf("media:group media:title = %q", got)
+	}
+}
+
+func TestParseUndeclaredPrefix(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0"><channel>
+    <item>
+      <description>Desc</description>
+      <content:encoded>Encoded</content:encoded>
+    </item>
+  </channel></rss>`)
+
+	if items[0].Content != "Encoded" {
+		t.Errorf("content = %q, want undeclared content:encoded", items[0].Content)
+	}
+}
+
+func TestParseRawJSONShape(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>
+    <item xml:base="http://example.com/">
+      <guid isPermaLink="false">g1</guid>
+      <dc:creator>Ann</dc:creator>
+    </item>
+  </channel></rss>`)
+
+	got, err := json.Marshal(items[0].Raw)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `{"dc:creator":[{"@":"Ann"}],"guid":[{"@":"g1","$":{"isPermaLink":"false"}}]}`
+	if string(got) != want {
+		t.Errorf("raw = %s\nwant  %s", got, want)
+	}
+}
+
+func TestParseAttachments(t *testing.T) {
+	items := collectFeed(t, `<?xml version="1.0"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+  <channel>
+    <item>
+      <title>Episode</title>
+      <enclosure url="http://example.com/ep.mp3" type="audio/mpeg" length="1234"/>
+      <media:content url="http://example.com/ep.mp3" duration="61.5"/>
+      <media:group>
+        <media:thumbnail url="http://example.com/group.jpg"/>
+        <media:content url="http://example.com/ep.mp4" type="video/mp4" fileSize="99"/>
+      </media:group>
+      <media:thumbnail url="http://example.com/item.jpg"/>
+    </item>
+    <item><title>Plain</title></item>
+  </channel>
+</rss>`)
+
+	want := []mod.Attachment{
+		{URL: "http://example.com/ep.mp3", Type: "audio/mpeg", Length: 1234, Duration: 61, Thumbnail: "http://example.com/item.jpg"},
+		{URL: "http://example.com/ep.mp4", Type: "video/mp4", Length: 99, Thumbnail: "http://example.com/group.jpg"},
+	}
+	if !slices.Equal(items[0].Attachments, want) {
+		t.Errorf("attachments = %+v\nwant %+v", items[0].Attachments, want)
+	}
+	if items[1].Attachments != nil {
+		t.Errorf("attachments = %+v, want none", items[1].Attachments)
+	}
+}
+
+func TestParseAtomEnclosure(t *testing.T) {
+	items := collectFeed(t, `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <title>Entry</title>
+    <link href="http://example.com/post"/>
+    <link rel="enclosure" href="http://example.com/a.ogg" type="audio/ogg" length="42"/>
+  </entry>
+</feed>`)
+
+	want := []mod.Attachment{{URL: "http://example.com/a.ogg", Type: "audio/ogg", Length: 42}}
+	if !slices.Equal(items[0].Attachments, want) {
+		t.Errorf("attachments = %+v, want %+v", items[0].Attachments, want)
+	}
+}
+
+func TestParseJSONFeedAttachments(t *testing.T) {
+	items := collectFeed(t, `{
+  "version": "https://jsonfeed.org/version/1",
+  "items": [{
+    "id": "1",
+    "image": "https://example.com/cover.png",
+    "attachments": [{"url": "https://example.com/a.m4a", "mime_type": "audio/x-m4a", "size_in_bytes": 10, "duration_in_seconds": 300}]
+  }]
+}`)
+
+	want := []mod.Attachment{{URL: "https://example.com/a.m4a", Type: "audio/x-m4a", Length: 10, Duration: 300, Thumbnail: "https://example.com/cover.png"}}
+	if !slices.Equal(items[0].Attachments, want) {
+		t.Errorf("attachments = %+v, want %+v", items[0].Attachments, want)
+	}
+}
+
+func TestParseAuthorCategories(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>
+    <item>
+      <author>ann@example.com (Ann Smith)</author>
+      <dc:creator>Ignored</dc:creator>
+      <category>Go</category>
+      <category domain="x"> RSS </category>
+      <dc:subject>Go</dc:subject>
+      <dc:subject>Feeds</dc:subject>
+    </item>
+    <item>
+      <dc:creator>Bob</dc:creator>
+      <dc:creator>Carol</dc:creator>
+    </item>
+  </channel></rss>`)
+
+	if items[0].Author != "Ann Smith" {
+		t.Errorf("author = %q, want name from RSS author", items[0].Author)
+	}
+	if want := []string{"Go", "RSS", "Feeds"}; !slices.Equal(items[0].Categories, want) {
+		t.Errorf("categories = %q, want %q", items[0].Categories, want)
+	}
+	if items[1].Author != "Bob, Carol" {
+		t.Errorf("author = %q, want dc:creator list", items[1].Author)
+	}
+}
+
+func TestParseAtomAuthorCategories(t *testing.T) {
+	items := collectFeed(t, `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <author><name>Ann</name><email>ann@example.com</email></author>
+    <author><email>bob@example.com</email></author>
+    <category term="tech" label="Technology"/>
+    <category term="go"/>
+  </entry>
+</feed>`)
+
+	if items[0].Author != "Ann, bob@example.com" {
+		t.Errorf("author = %q", items[0].Author)
+	}
+	if want := []string{"Technology", "go"}; !slices.Equal(items[0].Categories, want) {
+		t.Errorf("categories = %q, want %q", items[0].Categories, want)
+	}
+}
+
+func TestParseCharsets(t *testing.T) {
+	const latin1 = `<?xml version="1.0" encoding="ISO-8859-1"?>`
+	tests := []struct {
+		name        string
+		contentType string
+		decl        string
+		title       string
+		want        string
+	}{
+		{"ISO-8859-1 declaration", "", latin1, "Caf\xe9", "Café"},
+		{"windows-1252 declaration", "", `<?xml version="1.0" encoding="windows-1252"?>`, "\x93Quoted\x94", "“Quoted”"},
+		{"KOI8-R declaration", "", `<?xml version="1.0" encoding="KOI8-R"?>`, "\xf0\xd2\xc9\xd7\xc5\xd4", "Привет"},
+		{"content type charset", "application/rss+xml; charset=ISO-8859-1", "", "Caf\xe9", "Café"},
+		{"content type overrides declaration", "text/xml; charset=koi8-r", latin1, "\xf0\xd2\xc9\xd7\xc5\xd4", "Привет"},
+		{"UTF-8 content type defers to declaration", "text/xml; charset=utf-8", latin1, "Caf\xe9", "Café"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.decl + "<rss><channel><item><title>" + tt.title + "</title></item></channel></rss>"
+			items := collectFeedType(t, data, tt.contentType)
+			if items[0].Title != tt.want {
+				t.Errorf("title = %q, want %q", items[0].Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLenientEntities(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0"><channel>
+    <item><title>Fish&nbsp;&amp;&nbsp;Chips &copy;</title></item>
+  </channel></rss>`)
+
+	if items[0].Title != "Fish\u00a0&\u00a0Chips ©" {
+		t.Errorf("title = %q", items[0].Title)
+	}
+}
+
+func TestParseLenientUnclosedTags(t *testing.T) {
+	items := collectFeed(t, `<rss version="2.0"><channel>
+    <item><title>First</title></item>
+    <item><title>Second</title><description>Line<br>Next</description></item>
+    <item><title>Third</title></item>
+  </channel></rss>`)
+
+	var titles []string
+	for _, i := range items {
+		titles = append(titles, i.Title)
+	}
+	if want := []string{"First", "Second", "Third"}; !slices.Equal(titles, want) {
+		t.Errorf("titles = %q, want %q (each item exactly once)", titles, want)
+	}
+	if items[1].Content != "LineNext" {
+		t.Errorf("content = %q", items[1].Content)
+	}
+}
+
+func TestParseLenientKeepsCallbackErrors(t *testing.T) {
+	calls := 0
+	testErr := fmt.Errorf("module failed")
+	err := parseFeed(strings.NewReader(`<rss><channel><item><title>A&nbsp;</title></item></channel></rss>`), &feedDoc{}, func(*mod.RawItem) error {
+		calls++
+		return testErr
+	})
+	if err != testErr {
+		t.Errorf("err = %v, want %v", err, testErr)
+	}
+	if calls != 1 {
+		t.Errorf("callback called %d times, want 1", calls)
+	}
+}
+
+func TestParseRelativeURLs(t *testing.T) {
+	const item = `<item>
+      <link>posts/1</link>
+      <description><![CDATA[<a href="/about">a</a> <img src="img/x.png" srcset="x.png 1x, /x2.png 2x"> <a href="#note">n</a>]]></description>
+      <enclosure url="/ep.mp3" type="audio/mpeg"/>
+    </item>`
+
+	tests := []struct {
+		name    string
+		channel string
+		attrs   string
+		link    string
+		content string
+	}{
+		{
+			"subscription URL", "", "",
+			"http://feeds.example.com/blog/posts/1",
+			`<a href="http://feeds.example.com/about">a</a> <img src="http://feeds.example.com/blog/img/x.png" srcset="http://feeds.example.com/blog/x.png 1x, http://feeds.example.com/x2.png 2x"> <a href="#note">n</a>`,
+		},
+		{
+			"channel link", "<link>https://www.example.com/site/</link>", "",
+			"https://www.example.com/site/posts/1",
+			`<a href="https://www.example.com/about">a</a> <img src="https://www.example.com/site/img/x.png" srcset="https://www.example.com/site/x.png 1x, https://www.example.com/x2.png 2x"> <a href="#note">n</a>`,
+		},
+		{
+			"xml:base over channel link", "<link>https://www.example.com/site/</link>", ` xml:base="https://cdn.example.com/b/"`,
+			"https://cdn.example.com/b/posts/1",
+			`<a href="https://cdn.example.com/about">a</a> <img src="https://cdn.example.com/b/img/x.png" srcset="https://cdn.example.com/b/x.png 1x, https://cdn.example.com/x2.png 2x"> <a href="#note">n</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := `<rss version="2.0"><channel` + tt.attrs + `>` + tt.channel + item + `</channel></rss>`
+			items := collectFeedDoc(t, data, &feedDoc{URL: "http://feeds.example.com/blog/rss.xml"})
+			if items[0].Link != tt.link {
+				t.Errorf("link = %q, want %q", items[0].Link, tt.link)
+			}
+			if items[0].Content != tt.content {
+				t.Errorf("content = %q\nwant %q", items[0].Content, tt.content)
+			}
+			if want := resolveURL(parseURL(tt.link), "/ep.mp3"); items[0].Attachments[0].URL != want {
+				t.Errorf("attachment = %q, want %q", items[0].Attachments[0].URL, want)
+			}
+		})
+	}
+}
+
+func TestParseRelativeURLsAtom(t *testing.T) {
+	items := collectFeedDoc(t, `<feed xmlns="http://www.w3.org/2005/Atom" xml:base="http://example.com/blog/">
+  <link rel="alternate" href="http://other.example.com/"/>
+  <entry xml:base="2024/">
+    <link href="post.html"/>
+  </entry>
+  <entry>
+    <link href="http://absolute.example.com/x/../y"/>
+  </entry>
+</feed>`, &feedDoc{URL: "http://feeds.example.com/atom"})
+
+	if items[0].Link != "http://example.com/blog/2024/post.html" {
+		t.Errorf("link = %q, want nested xml:base resolution", items[0].Link)
+	}
+	if items[1].Link != "http://absolute.example.com/x/../y" {
+		t.Errorf("absolute link = %q, want unchanged", items[1].Link)
+	}
+}
+
+func TestParseRelativeURLsJSONFeed(t *testing.T) {
+	items := collectFeedDoc(t, `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "home_page_url": "https://example.com/blog/",
+  "items": [{"id": "1", "url": "p/1", "content_html": "<img src=\"i.png\">"}]
+}`, &feedDoc{URL: "https://feeds.example.com/feed.json"})
+
+	if items[0].Link != "https://example.com/blog/p/1" {
+		t.Errorf("link = %q", items[0].Link)
+	}
+	if items[0].Content != `<img src="https://example.com/blog/i.png">` {
+		t.Errorf("content = %q", items[0].Content)
+	}
+}
+
+func TestParseFeedInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want FeedInfo
+	}{
+		{"RSS", `<rss version="2.0"><channel>
+    <title>RSS Site</title>
+    <link>https://example.com/</link>
+    <description>About RSS</description>
+    <language>en-us</language>
+    <image><url>/logo.png</url><title>RSS Site</title></image>
+    <item><title>A</title></item>
+  </channel></rss>`, FeedInfo{Title: "RSS Site", Description: "About RSS", Link: "https://example.com/", Language: "en-us", Icon: "https://example.com/logo.png"}},
+		{"Atom", `<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="de">
+    <title>Atom Site</title>
+    <subtitle>About Atom</subtitle>
+    <link rel="self" href="https://example.com/atom.xml"/>
+    <link rel="alternate" href="https://example.com/blog/"/>
+    <icon>https://example.com/icon.png</icon>
+    <logo>https://example.com/logo.png</logo>
+    <entry><title>A</title></entry>
+  </feed>`, FeedInfo{Title: "Atom Site", Description: "About Atom", Link: "https://example.com/blog/", Language: "de", Icon: "https://example.com/icon.png"}},
+		{"favicon fallback", `<rss version="2.0"><channel>
+    <title>Plain</title>
+    <link>https://www.example.com/blog/</link>
+  </channel></rss>`, FeedInfo{Title: "Plain", Link: "https://www.example.com/blog/", Icon: "https://www.example.com/favicon.ico"}},
+		{"JSON Feed", `{
+    "version": "https://jsonfeed.org/version/1.1",
+    "title": "JSON Site",
+    "description": "About JSON",
+    "home_page_url": "https://example.com/",
+    "icon": "https://example.com/big.png",
+    "favicon": "/small.png",
+    "language": "fr",
+    "items": []
+  }`, FeedInfo{Title: "JSON Site", Description: "About JSON", Link: "https://example.com/", Language: "fr", Icon: "https://feeds.example.com/small.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &feedDoc{URL: "https://feeds.example.com/feed"}
+			collectFeedDoc(t, tt.data, doc)
+			if doc.Info != tt.want {
+				t.Errorf("info = %+v\nwant %+v", doc.Info, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedInfoBeforeItems(t *testing.T) {
+	doc := &feedDoc{}
+	var title string
+	err := parseFeed(strings.NewReader(`<rss version="2.0"><channel>
+    <title>Early</title>
+    <item><title>A</title></item>
+  </channel></rss>`), doc, func(*mod.RawItem) error {
+		title = doc.Info.Title
+		return ErrStopFeed
+	})
+	if err != nil {
+		t.Fatalf("parseFeed: %v", err)
+	}
+	if title != "Early" {
+		t.Errorf("title seen by callback = %q, want header title", title)
+	}
+}
+
 func TestParseRawFieldPreserved(t *testing.T) {
 	items := collectFeed(t, `<rss version="2.0"><channel>
     <item>
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Subscription health states, as shown by ls.
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+	healthPaused  = "paused"
+)
+
+// succeeded records a successful fetch of s at now.
+func (s *Subscription) succeeded(now time.Time) {
+	s.FetchError = ""
+	s.Failures = 0
+	s.FailingSince = 0
+	s.LastSuccess = now.Unix()
+}
+
+// failed records a fetch of s at now that failed with err, and backs off
+// its next one. The subscription is paused once it failed the configured
+// number of times in a row, or right away when the feed is gone for good
+// (410).
+func (s *Subscription) failed(now time.Time, err error) {
+	s.FetchError = err.Error()
+	if s.Failures == 0 {
+		s.FailingSince = now.Unix()
+	}
+	s.Failures++
+	s.retryLater(now)
+
+	var se *statusError
+	switch {
+	case errors.As(err, &se) && se.code == http.StatusGone:
+		s.Paused = true
+	case globals.MaxFailures > 0 && s.Failures >= globals.MaxFailures:
+		s.Paused = true
+	}
+	if s.Paused {
+		slog.Warn("subscription paused", "sub", s, "failures", s.Failures)
+	}
+}
+
+// resume lets a paused subscription be fetched again, with a clean failure
+// count.
+func (s *Subscription) resume() {
+	s.Paused = false
+	s.Failures = 0
+	s.FailingSince = 0
+}
+
+func (s *Subscription) health() string {
+	switch {
+	case s.Paused:
+		return healthPaused
+	case s.Failures > 0:
+		return healthFailing
+	}
+	return healthOK
+}
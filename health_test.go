@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gllera/srrb/mod"
+)
+
+func TestHealthTracking(t *testing.T) {
+	globals = &Globals{MaxFailures: 3}
+	now := time.Unix(1_000_000, 0)
+	s := &Subscription{}
+
+	s.failed(now, errors.New("boom"))
+	s.failed(now.Add(time.Hour), errors.New("boom again"))
+	if s.Failures != 2 || s.FailingSince != now.Unix() || s.FetchError != "boom again" {
+		t.Fatalf("after 2 failures: %+v", s)
+	}
+	if s.health() != healthFailing {
+		t.Errorf("health = %q, want %q", s.health(), healthFailing)
+	}
+
+	s.succeeded(now.Add(2 * time.Hour))
+	if s.Failures != 0 || s.FailingSince != 0 || s.FetchError != "" || s.LastSuccess != now.Add(2*time.Hour).Unix() {
+		t.Fatalf("after success: %+v", s)
+	}
+	if s.health() != healthOK {
+		t.Errorf("health = %q, want %q", s.health(), healthOK)
+	}
+
+	for range 3 {
+		s.failed(now, errors.New("boom"))
+	}
+	if !s.Paused || s.health() != healthPaused {
+		t.Fatalf("not paused after %d failures", s.Failures)
+	}
+
+	s.resume()
+	if s.Paused || s.Failures != 0 || s.FailingSince != 0 {
+		t.Errorf("after resume: %+v", s)
+	}
+
+	globals.MaxFailures = 0
+	for range 20 {
+		s.failed(now, errors.New("boom"))
+	}
+	if s.Paused {
+		t.Error("paused with auto-pause disabled")
+	}
+}
+
+func TestFetchGonePauses(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100, MaxFailures: 10}
+	var hits int
+	srv := flakyServer(t, 1, failStatus(http.StatusGone, ""), &hits)
+
+	s := &Subscription{URL: srv.URL}
+	err := s.Fetch(context.Background(), newHTTPClient(), mod.New())
+	if err == nil {
+		t.Fatal("Fetch succeeded on 410")
+	}
+	s.failed(time.Now(), err)
+	if !s.Paused || s.Failures != 1 {
+		t.Errorf("paused = %v, failures = %d, want paused after one 410", s.Paused, s.Failures)
+	}
+}
+
+func TestFailedBacksOff(t *testing.T) {
+	globals = &Globals{MinInterval: 15 * time.Minute, MaxInterval: 24 * time.Hour}
+	now := time.Unix(1_000_000, 0)
+	s := &Subscription{Interval: 3600}
+
+	for _, want := range []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour} {
+		s.failed(now, errors.New("boom"))
+		if got := time.Duration(s.NextFetch-now.Unix()) * time.Second; got != want {
+			t.Fatalf("after %d failures next fetch in %v, want %v", s.Failures, got, want)
+		}
+	}
+	for range 20 {
+		s.failed(now, errors.New("boom"))
+	}
+	if got := time.Duration(s.NextFetch-now.Unix()) * time.Second; got != 24*time.Hour {
+		t.Errorf("backoff capped at %v, want the max interval", got)
+	}
+	if s.Interval != 3600 {
+		t.Errorf("interval = %d, want the one of successful fetches kept", s.Interval)
+	}
+}
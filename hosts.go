@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gllera/srrb/backend"
+)
+
+// HostLimits are the politeness limits of one host, set in the "hosts"
+// section of the config file. Unset fields take the global flags.
+type HostLimits struct {
+	Concurrency int            `yaml:"concurrency"`
+	Delay       *time.Duration `yaml:"delay"`
+}
+
+var hostsCfg = map[string]HostLimits{}
+
+func init() {
+	backend.RegisterConfig("hosts", &hostsCfg)
+}
+
+// hostLimits returns the limits of host, from its own config entry or else
+// that of its closest parent domain, completed by the global flags.
+func hostLimits(host string) (concurrency int, delay time.Duration) {
+	concurrency, delay = globals.HostConcurrency, globals.HostDelay
+	for h := host; h != ""; {
+		if l, ok := hostsCfg[h]; ok {
+			if l.Concurrency > 0 {
+				concurrency = l.Concurrency
+			}
+			if l.Delay != nil {
+				delay = *l.Delay
+			}
+			break
+		}
+		_, h, _ = strings.Cut(h, ".")
+	}
+	return max(concurrency, 1), max(delay, 0)
+}
+
+func subHost(s *Subscription) string {
+	if u, err := url.Parse(s.URL); err == nil {
+		return strings.ToLower(u.Hostname())
+	}
+	return ""
+}
+
+// hostQueue hands subscriptions out to the fetch workers, in order, except
+// that a subscription waits while its host has as many requests in flight
+// as its concurrency limit, or until the host delay has passed since the
+// last request to it started. Other hosts are served in the meantime.
+type hostQueue struct {
+	mu      sync.Mutex
+	subs    []*Subscription
+	active  map[string]int
+	next    map[string]time.Time
+	taken   map[*Subscription]string // host charged, as fetching may move s
+	changed chan struct{}
+}
+
+func newHostQueue(subs []*Subscription) *hostQueue {
+	return &hostQueue{
+		subs:    subs,
+		active:  map[string]int{},
+		next:    map[string]time.Time{},
+		taken:   map[*Subscription]string{},
+		changed: make(chan struct{}),
+	}
+}
+
+// take returns the next subscription to fetch, waiting for one to be
+// allowed, or nil when all were handed out. Each must be released with
+// done once fetched.
+func (q *hostQueue) take(ctx context.Context) (*Subscription, error) {
+	for {
+		q.mu.Lock()
+		if len(q.subs) == 0 {
+			q.mu.Unlock()
+			return nil, nil
+		}
+
+		now := time.Now()
+		wait := time.Duration(-1)
+		for i, s := range q.subs {
+			host := subHost(s)
+			concurrency, delay := hostLimits(host)
+			if q.active[host] >= concurrency {
+				continue
+			}
+			if d := q.next[host].Sub(now); d > 0 {
+				if wait < 0 || d < wait {
+					wait = d
+				}
+				continue
+			}
+			q.subs = append(q.subs[:i], q.subs[i+1:]...)
+			q.active[host]++
+			q.next[host] = now.Add(delay)
+			q.taken[s] = host
+			q.mu.Unlock()
+			return s, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// done releases the host slot taken by s, that of its url when it was
+// taken.
+func (q *hostQueue) done(s *Subscription) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active[q.taken[s]]--
+	delete(q.taken, s)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gllera/srrb/backend"
+)
+
+func TestHostLimits(t *testing.T) {
+	globals = &Globals{HostConcurrency: 2, HostDelay: time.Second}
+	zero := time.Duration(0)
+	hostsCfg = map[string]HostLimits{
+		"feedburner.com":     {Concurrency: 1},
+		"forum.example.com":  {Delay: &zero},
+		"slow.example.com":   {Concurrency: 3, Delay: new(5 * time.Second)},
+		"broken.example.com": {Concurrency: -1},
+	}
+	t.Cleanup(func() { hostsCfg = map[string]HostLimits{} })
+
+	tests := []struct {
+		host        string
+		concurrency int
+		delay       time.Duration
+	}{
+		{"example.com", 2, time.Second},
+		{"feeds.feedburner.com", 1, time.Second},
+		{"forum.example.com", 2, 0},
+		{"slow.example.com", 3, 5 * time.Second},
+		{"broken.example.com", 2, time.Second},
+	}
+	for _, tt := range tests {
+		c, d := hostLimits(tt.host)
+		if c != tt.concurrency || d != tt.delay {
+			t.Errorf("hostLimits(%q) = %d, %v; want %d, %v", tt.host, c, d, tt.concurrency, tt.delay)
+		}
+	}
+}
+
+// takeNow takes from q, failing if nothing is handed out within d.
+func takeNow(t *testing.T, q *hostQueue, d time.Duration) *Subscription {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	s, err := q.take(ctx)
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+func TestHostQueueConcurrency(t *testing.T) {
+	globals = &Globals{HostConcurrency: 1}
+	a1 := &Subscription{URL: "http://a.com/1"}
+	a2 := &Subscription{URL: "http://A.com/2"}
+	b1 := &Subscription{URL: "http://b.com/1"}
+	q := newHostQueue([]*Subscription{a1, a2, b1})
+
+	if s := takeNow(t, q, time.Second); s != a1 {
+		t.Fatalf("first = %v, want a1", s)
+	}
+	// a.com is busy: b.com goes ahead of a2.
+	if s := takeNow(t, q, time.Second); s != b1 {
+		t.Fatalf("second = %v, want b1", s)
+	}
+	if s := takeNow(t, q, 50*time.Millisecond); s != nil {
+		t.Fatalf("got %v while a.com is busy", s)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.done(a1)
+	}()
+	if s := takeNow(t, q, time.Second); s != a2 {
+		t.Fatalf("third = %v, want a2 once a1 is done", s)
+	}
+	if s, err := q.take(context.Background()); s != nil || err != nil {
+		t.Errorf("empty queue = %v, %v", s, err)
+	}
+}
+
+func TestHostQueueDelay(t *testing.T) {
+	globals = &Globals{HostConcurrency: 5, HostDelay: 100 * time.Millisecond}
+	q := newHostQueue([]*Subscription{
+		{URL: "http://a.com/1"},
+		{URL: "http://a.com/2"},
+	})
+
+	start := time.Now()
+	takeNow(t, q, time.Second)
+	if s := takeNow(t, q, time.Second); s == nil {
+		t.Fatal("second subscription not handed out")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("second request after %v, want at least the host delay", elapsed)
+	}
+}
+
+func TestHostsConfig(t *testing.T) {
+	t.Cleanup(func() { hostsCfg = map[string]HostLimits{} })
+	path := filepath.Join(t.TempDir(), "srr.yaml")
+	os.WriteFile(path, []byte("hosts:\n  example.com:\n    concurrency: 1\n    delay: 2s\n"), 0o644)
+
+	if err := backend.LoadConfigs(path); err != nil {
+		t.Fatal(err)
+	}
+	l := hostsCfg["example.com"]
+	if l.Concurrency != 1 || l.Delay == nil || *l.Delay != 2*time.Second {
+		t.Errorf("limits = %+v", l)
+	}
+}
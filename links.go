@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/gllera/srrb/mod"
+)
+
+// parseURL parses s, returning nil when it is empty or invalid.
+func parseURL(s string) *url.URL {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// resolveBase resolves ref against base to be used as a new base. If ref
+// cannot be parsed, base is kept.
+func resolveBase(base *url.URL, ref string) *url.URL {
+	u := parseURL(ref)
+	if u == nil {
+		return base
+	}
+	if base == nil {
+		return u
+	}
+	return base.ResolveReference(u)
+}
+
+// resolveURL resolves ref against base. Absolute URLs, fragment-only
+// references and anything that fails to parse are returned unchanged.
+func resolveURL(base *url.URL, ref string) string {
+	if base == nil || ref == "" || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.IsAbs() {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// resolveSrcset resolves every candidate URL of an srcset attribute.
+func resolveSrcset(base *url.URL, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = resolveURL(base, fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// resolveHTML rewrites relative href, src, srcset and poster attributes of
+// an HTML fragment into absolute URLs. Tags without relative URLs are
+// copied verbatim; if the fragment cannot be tokenized it is returned as is.
+func resolveHTML(base *url.URL, s string) string {
+	if base == nil || s == "" {
+		return s
+	}
+
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return s
+			}
+			return b.String()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			raw := string(z.Raw())
+			tok := z.Token()
+			changed := false
+			for i, a := range tok.Attr {
+				val := a.Val
+				switch a.Key {
+				case "href", "src", "poster":
+					val = resolveURL(base, a.Val)
+				case "srcset":
+					val = resolveSrcset(base, a.Val)
+				}
+				if val != a.Val {
+					tok.Attr[i].Val = val
+					changed = true
+				}
+			}
+			if changed {
+				b.WriteString(tok.String())
+			} else {
+				b.WriteString(raw)
+			}
+		default:
+			b.Write(z.Raw())
+		}
+	}
+}
+
+// resolveItemURLs makes the link, attachments and content URLs of an item
+// absolute, using base as reference.
+func resolveItemURLs(i *mod.RawItem, base *url.URL) {
+	if base == nil {
+		return
+	}
+	i.Link = resolveURL(base, i.Link)
+	i.Content = resolveHTML(base, i.Content)
+	for k := range i.Attachments {
+		a := &i.Attachments[k]
+		a.URL = resolveURL(base, a.URL)
+		a.Thumbnail = resolveURL(base, a.Thumbnail)
+	}
+	if p := i.Podcast; p != nil {
+		p.Image = resolveURL(base, p.Image)
+		for k := range p.Transcripts {
+			p.Transcripts[k].URL = resolveURL(base, p.Transcripts[k].URL)
+		}
+	}
+}
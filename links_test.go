@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestResolveURL(t *testing.T) {
+	base := parseURL("https://example.com/blog/post/")
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"img.png", "https://example.com/blog/post/img.png"},
+		{"/img.png", "https://example.com/img.png"},
+		{"../img.png", "https://example.com/blog/img.png"},
+		{"//cdn.example.com/x.png", "https://cdn.example.com/x.png"},
+		{"?page=2", "https://example.com/blog/post/?page=2"},
+		{"#section", "#section"},
+		{"mailto:ann@example.com", "mailto:ann@example.com"},
+		{"http://other.example.com/a/../b", "http://other.example.com/a/../b"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := resolveURL(base, tt.ref); got != tt.want {
+			t.Errorf("resolveURL(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+
+	if got := resolveURL(nil, "img.png"); got != "img.png" {
+		t.Errorf("resolveURL without base = %q, want unchanged", got)
+	}
+}
+
+func TestResolveHTML(t *testing.T) {
+	base := parseURL("https://example.com/a/")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`<p>No links</p>`, `<p>No links</p>`},
+		{`<A HREF="x">x</A>`, `<a href="https://example.com/a/x">x</A>`},
+		{`<img src="/i.png" alt="a &amp; b"/>`, `<img src="https://example.com/i.png" alt="a &amp; b"/>`},
+		{`<video poster="p.jpg"><source src="v.mp4"></video>`, `<video poster="https://example.com/a/p.jpg"><source src="https://example.com/a/v.mp4"></video>`},
+		{`<img srcset="s.png 480w,l.png 800w">`, `<img srcset="https://example.com/a/s.png 480w, https://example.com/a/l.png 800w">`},
+		{`<a href="https://other.example.com/" class=x>y</a>`, `<a href="https://other.example.com/" class=x>y</a>`},
+		{`text & <b>bold</b><!-- c -->`, `text & <b>bold</b><!-- c -->`},
+	}
+
+	for _, tt := range tests {
+		if got := resolveHTML(base, tt.in); got != tt.want {
+			t.Errorf("resolveHTML(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+		}
+	}
+}
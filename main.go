@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/alecthomas/kong"
 	kongyaml "github.com/alecthomas/kong-yaml"
@@ -16,12 +17,22 @@ var version = "development"
 var globals *Globals
 
 type Globals struct {
-	Workers     int    `short:"w" default:"${nproc}" env:"SRR_WORKERS"       help:"Number of concurrent downloads."`
-	PackSize    int    `short:"s" default:"200"      env:"SRR_PACK_SIZE"     help:"Target pack size in KB."`
-	MaxFeedSize int    `short:"m" default:"5000"     env:"SRR_MAX_FEED_SIZE" help:"Max feed download size in KB."`
-	Store       string `short:"o" default:"packs"    env:"SRR_STORE"         help:"Storage destination path."`
-	Force       bool   `                             env:"SRR_FORCE"         help:"Override DB write lock if needed."`
-	Debug       bool   `short:"d"                    env:"SRR_DEBUG"         help:"Enable debug mode."`
+	Workers         int           `short:"w" default:"${nproc}" env:"SRR_WORKERS"          help:"Number of concurrent downloads."`
+	PackSize        int           `short:"s" default:"200"      env:"SRR_PACK_SIZE"        help:"Target pack size in KB."`
+	MaxFeedSize     int           `short:"m" default:"5000"     env:"SRR_MAX_FEED_SIZE"    help:"Max feed download size in KB."`
+	SeenWindow      int           `          default:"100"      env:"SRR_SEEN_WINDOW"      help:"Item ids remembered per subscription to detect duplicates."`
+	Retries         int           `          default:"2"        env:"SRR_RETRIES"          help:"Retries of transient fetch failures (timeouts, 429, 502-504)."`
+	RetryBackoff    time.Duration `          default:"1s"       env:"SRR_RETRY_BACKOFF"    help:"Wait before the first retry, doubled on each one."`
+	RetryMaxWait    time.Duration `          default:"1m"       env:"SRR_RETRY_MAX_WAIT"   help:"Longest wait between retries. Longer Retry-After fail the fetch."`
+	HostConcurrency int           `          default:"2"        env:"SRR_HOST_CONCURRENCY" help:"Max concurrent requests to one host."`
+	HostDelay       time.Duration `          default:"500ms"    env:"SRR_HOST_DELAY"       help:"Min delay between requests to one host."`
+	MinInterval     time.Duration `          default:"15m"      env:"SRR_MIN_INTERVAL"     help:"Min time between fetches of a subscription."`
+	MaxInterval     time.Duration `          default:"24h"      env:"SRR_MAX_INTERVAL"     help:"Max time between fetches of a subscription."`
+	MaxFailures     int           `          default:"10"       env:"SRR_MAX_FAILURES"     help:"Consecutive failed fetches that pause a subscription. 0 to never pause."`
+	RedirectAfter   int           `          default:"3"        env:"SRR_REDIRECT_AFTER"   help:"Fetches in a row permanently redirected to the same url before a subscription moves there. 0 to never move."`
+	Store           string        `short:"o" default:"packs"    env:"SRR_STORE"            help:"Storage destination path."`
+	Force           bool          `                             env:"SRR_FORCE"            help:"Override DB write lock if needed."`
+	Debug           bool          `short:"d"                    env:"SRR_DEBUG"            help:"Enable debug mode."`
 }
 
 type CLI struct {
@@ -96,6 +107,46 @@ func main() {
 		globals.MaxFeedSize = 5000
 	}
 
+	if globals.SeenWindow < 1 {
+		globals.SeenWindow = 100
+	}
+
+	if globals.Retries < 0 {
+		globals.Retries = 0
+	}
+
+	if globals.RetryBackoff <= 0 {
+		globals.RetryBackoff = time.Second
+	}
+
+	if globals.RetryMaxWait <= 0 {
+		globals.RetryMaxWait = time.Minute
+	}
+
+	if globals.HostConcurrency < 1 {
+		globals.HostConcurrency = 2
+	}
+
+	if globals.HostDelay < 0 {
+		globals.HostDelay = 0
+	}
+
+	if globals.MinInterval < 0 {
+		globals.MinInterval = 0
+	}
+
+	if globals.MaxInterval <= 0 {
+		globals.MaxInterval = 24 * time.Hour
+	}
+
+	if globals.MaxFailures < 0 {
+		globals.MaxFailures = 0
+	}
+
+	if globals.RedirectAfter < 0 {
+		globals.RedirectAfter = 0
+	}
+
 	if globals.Workers < 1 {
 		globals.Workers = runtime.NumCPU()
 	}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is a family of the Prometheus text exposition format, with one
+// series per label set. Label sets are kept preformatted, as in
+// `phase="parse"`.
+type metric struct {
+	r                *registry
+	name, help, kind string
+	buckets          []float64
+	values           map[string]float64
+	hists            map[string]*histogram
+}
+
+type histogram struct {
+	Counts []uint64 `json:"counts"` // per bucket, not cumulative
+	Sum    float64  `json:"sum"`
+	Count  uint64   `json:"count"`
+}
+
+// registry holds the metrics of the process.
+type registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func (r *registry) add(name, help, kind string, buckets []float64) *metric {
+	m := &metric{r: r, name: name, help: help, kind: kind, buckets: buckets, values: map[string]float64{}, hists: map[string]*histogram{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *registry) counter(name, help string) *metric { return r.add(name, help, "counter", nil) }
+func (r *registry) gauge(name, help string) *metric   { return r.add(name, help, "gauge", nil) }
+func (r *registry) histogram(name, help string, buckets []float64) *metric {
+	return r.add(name, help, "histogram", buckets)
+}
+
+// metricsState is what db.json keeps of the counters and histograms, by
+// metric name and label set, so they accumulate across runs as Prometheus
+// expects of them. Gauges describe the last run only and are not kept.
+type metricsState struct {
+	Counters   map[string]map[string]float64    `json:"counters,omitempty"`
+	Histograms map[string]map[string]*histogram `json:"histograms,omitempty"`
+}
+
+// load replaces every series with those of st, if not nil. Histograms
+// whose buckets changed since they were kept start over.
+func (r *registry) load(st *metricsState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		clear(m.values)
+		clear(m.hists)
+		if st == nil {
+			continue
+		}
+		switch m.kind {
+		case "counter":
+			maps.Copy(m.values, st.Counters[m.name])
+		case "histogram":
+			for labels, h := range st.Histograms[m.name] {
+				if h != nil && len(h.Counts) == len(m.buckets)+1 {
+					m.hists[labels] = &histogram{Counts: slices.Clone(h.Counts), Sum: h.Sum, Count: h.Count}
+				}
+			}
+		}
+	}
+}
+
+// state returns the counters and histograms of r for db.json.
+func (r *registry) state() *metricsState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := &metricsState{Counters: map[string]map[string]float64{}, Histograms: map[string]map[string]*histogram{}}
+	for _, m := range r.metrics {
+		switch m.kind {
+		case "counter":
+			if len(m.values) > 0 {
+				st.Counters[m.name] = maps.Clone(m.values)
+			}
+		case "histogram":
+			if len(m.hists) > 0 {
+				hists := make(map[string]*histogram, len(m.hists))
+				for labels, h := range m.hists {
+					hists[labels] = &histogram{Counts: slices.Clone(h.Counts), Sum: h.Sum, Count: h.Count}
+				}
+				st.Histograms[m.name] = hists
+			}
+		}
+	}
+	return st
+}
+
+// label formats a label pair for the series of a metric.
+func label(name, value string) string {
+	return name + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func (m *metric) add(labels string, v float64) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.values[labels] += v
+}
+
+func (m *metric) set(labels string, v float64) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.values[labels] = v
+}
+
+func (m *metric) observe(labels string, v float64) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	h := m.hists[labels]
+	if h == nil {
+		h = &histogram{Counts: make([]uint64, len(m.buckets)+1)}
+		m.hists[labels] = h
+	}
+	i, _ := slices.BinarySearch(m.buckets, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// series writes the sample of name with labels, extra being appended to
+// them.
+func series(w io.Writer, name, labels, extra string, v float64) {
+	switch {
+	case labels != "" && extra != "":
+		labels = "{" + labels + "," + extra + "}"
+	case labels != "" || extra != "":
+		labels = "{" + labels + extra + "}"
+	}
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// write writes the metrics in the text exposition format.
+func (r *registry) write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		if len(m.values) == 0 && len(m.hists) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, labels := range sortedKeys(m.values) {
+			series(w, m.name, labels, "", m.values[labels])
+		}
+		for _, labels := range sortedKeys(m.hists) {
+			h := m.hists[labels]
+			var cum uint64
+			for i, le := range m.buckets {
+				cum += h.Counts[i]
+				series(w, m.name+"_bucket", labels, label("le", strconv.FormatFloat(le, 'g', -1, 64)), float64(cum))
+			}
+			series(w, m.name+"_bucket", labels, label("le", "+Inf"), float64(h.Count))
+			series(w, m.name+"_sum", labels, "", h.Sum)
+			series(w, m.name+"_count", labels, "", float64(h.Count))
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.write(w)
+}
+
+// writeFile writes the metrics to path for the node exporter textfile
+// collector, through a rename so it never reads a partial file.
+func (r *registry) writeFile(path string) error {
+	var buf bytes.Buffer
+	r.write(&buf)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".srrb-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// push replaces the metrics of the srrb job in the Pushgateway at url.
+func (r *registry) push(ctx context.Context, client *http.Client, url string) error {
+	var buf bytes.Buffer
+	r.write(&buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(url, "/")+"/metrics/job/srrb", &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return &statusError{res.StatusCode, res.Status}
+	}
+	return nil
+}
+
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	metrics = &registry{}
+
+	mFetched = metrics.counter("srrb_fetch_subscriptions_total",
+		"Subscriptions fetched, by result: ok, not_modified or failed.")
+	mFailures = metrics.counter("srrb_fetch_failures_total",
+		"Failed subscription fetches, by class.")
+	mItemsAdded = metrics.counter("srrb_fetch_items_added_total",
+		"Articles added to the store.")
+	mBytes = metrics.counter("srrb_fetch_downloaded_bytes_total",
+		"Feed bytes downloaded.")
+	mPhase = metrics.histogram("srrb_fetch_phase_duration_seconds",
+		"Time spent per phase: download and parse per subscription, pipeline per subscription, upload per run.", durationBuckets)
+	mLastRun = metrics.gauge("srrb_fetch_last_run_timestamp_seconds",
+		"When the last fetch run finished.")
+	mRunDuration = metrics.gauge("srrb_fetch_last_run_duration_seconds",
+		"Duration of the last fetch run.")
+	mRunSuccess = metrics.gauge("srrb_fetch_last_run_success",
+		"Whether the last fetch run stored its articles and database: 1 or 0.")
+)
+
+// failureClass sorts a fetch error for the failures metric.
+func failureClass(err error, st fetchStats) string {
+	if st.Failure != "" {
+		return st.Failure
+	}
+	var se *statusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &se):
+		return fmt.Sprintf("http_%dxx", se.code/100)
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection"
+	}
+	return "other"
+}
+
+// observeFetch records a feed fetch with stats st that failed with err,
+// if not nil.
+func observeFetch(st fetchStats, err error) {
+	switch {
+	case err != nil:
+		mFetched.add(label("result", "failed"), 1)
+		mFailures.add(label("class", failureClass(err, st)), 1)
+	case st.NotModified:
+		mFetched.add(label("result", "not_modified"), 1)
+	default:
+		mFetched.add(label("result", "ok"), 1)
+	}
+	mBytes.add("", float64(st.Bytes))
+	if st.Download > 0 {
+		mPhase.observe(label("phase", "download"), st.Download.Seconds())
+	}
+	if st.Parse > 0 {
+		mPhase.observe(label("phase", "parse"), st.Parse.Seconds())
+	}
+	if st.Pipeline > 0 {
+		mPhase.observe(label("phase", "pipeline"), st.Pipeline.Seconds())
+	}
+}
+
+// observeUpload records the storing of the given number of articles, which
+// took upload.
+func observeUpload(added int, upload time.Duration) {
+	mItemsAdded.add("", float64(added))
+	mPhase.observe(label("phase", "upload"), upload.Seconds())
+}
+
+// observeRun records a fetch run that started at start and failed with err,
+// if not nil.
+func observeRun(start time.Time, err error) {
+	mLastRun.set("", float64(time.Now().Unix()))
+	mRunDuration.set("", time.Since(start).Seconds())
+	if err != nil {
+		mRunSuccess.set("", 0)
+	} else {
+		mRunSuccess.set("", 1)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := &registry{}
+	c := r.counter("test_total", "A counter.")
+	g := r.gauge("test_gauge", "A gauge.")
+	h := r.histogram("test_seconds", "A histogram.", []float64{0.1, 1})
+	r.counter("test_unused_total", "Never set.")
+
+	c.add(label("result", "ok"), 2)
+	c.add(label("result", "ok"), 1)
+	c.add(label("result", `say "hi"`), 1)
+	g.set("", 5)
+	g.set("", 7)
+	h.observe(label("phase", "parse"), 0.05)
+	h.observe(label("phase", "parse"), 0.1)
+	h.observe(label("phase", "parse"), 3)
+
+	var b strings.Builder
+	r.write(&b)
+	want := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{result="ok"} 3
+test_total{result="say \"hi\""} 1
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 7
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{phase="parse",le="0.1"} 2
+test_seconds_bucket{phase="parse",le="1"} 2
+test_seconds_bucket{phase="parse",le="+Inf"} 3
+test_seconds_sum{phase="parse"} 3.15
+test_seconds_count{phase="parse"} 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryState(t *testing.T) {
+	r := &registry{}
+	c := r.counter("test_total", "A counter.")
+	g := r.gauge("test_gauge", "A gauge.")
+	h := r.histogram("test_seconds", "A histogram.", []float64{0.1, 1})
+	c.add("", 2)
+	g.set("", 5)
+	h.observe("", 0.5)
+
+	// Counters and histograms carry on from the state, gauges do not.
+	data, err := json.Marshal(r.state())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st *metricsState
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	r.load(st)
+	c.add("", 1)
+	h.observe("", 3)
+
+	var b strings.Builder
+	r.write(&b)
+	for _, want := range []string{"test_total 3\n", `test_seconds_bucket{le="1"} 1` + "\n", "test_seconds_count 2\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "test_gauge") {
+		t.Errorf("gauge kept:\n%s", b.String())
+	}
+
+	// Histograms whose buckets changed start over.
+	r2 := &registry{}
+	r2.histogram("test_seconds", "A histogram.", []float64{1})
+	if r2.load(st); len(r2.state().Histograms) != 0 {
+		t.Errorf("histogram with other buckets loaded")
+	}
+}
+
+func TestFailureClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		st   fetchStats
+		want string
+	}{
+		{&statusError{404, "404 Not Found"}, fetchStats{}, "http_4xx"},
+		{fmt.Errorf("wrapped: %w", &statusError{503, "503"}), fetchStats{}, "http_5xx"},
+		{&net.DNSError{Err: "no such host"}, fetchStats{}, "dns"},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, fetchStats{}, "connection"},
+		{io.ErrUnexpectedEOF, fetchStats{}, "connection"},
+		{errors.New("boom"), fetchStats{Failure: "parse"}, "parse"},
+		{errors.New("boom"), fetchStats{}, "other"},
+	}
+	for _, tt := range tests {
+		if got := failureClass(tt.err, tt.st); got != tt.want {
+			t.Errorf("failureClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryWriteFile(t *testing.T) {
+	r := &registry{}
+	r.gauge("test_gauge", "A gauge.").set("", 1)
+	path := filepath.Join(t.TempDir(), "srrb.prom")
+	if err := r.writeFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "test_gauge 1\n") {
+		t.Errorf("file = %s", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+// pushgateway is a Pushgateway stand-in keeping the last push.
+type pushgateway struct {
+	mu     sync.Mutex
+	method string
+	path   string
+	body   string
+}
+
+func newPushgateway(t *testing.T) (*pushgateway, *httptest.Server) {
+	t.Helper()
+	pg := &pushgateway{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		pg.mu.Lock()
+		pg.method, pg.path, pg.body = r.Method, r.URL.Path, string(data)
+		pg.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return pg, srv
+}
+
+func TestRegistryPush(t *testing.T) {
+	r := &registry{}
+	r.counter("test_total", "A counter.").add("", 1)
+	pg, srv := newPushgateway(t)
+
+	if err := r.push(context.Background(), newHTTPClient(), srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if pg.method != http.MethodPut || pg.path != "/metrics/job/srrb" || !strings.Contains(pg.body, "test_total 1\n") {
+		t.Errorf("pushed %s %s:\n%s", pg.method, pg.path, pg.body)
+	}
+}
+
+func TestFetchWritesMetricsOnUploadFailure(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, Store: dir}
+	feed := serveFeed(t, rssItems("a"))
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: feed.URL})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+	// A file where the index packs go makes the upload fail.
+	if err := os.WriteFile(filepath.Join(dir, "idx"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "srrb.prom")
+	if err := (&FetchCmd{MetricsFile: path}).Run(); err == nil {
+		t.Fatal("Run: expected upload error")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("metrics not written: %v", err)
+	}
+	for _, want := range []string{`srrb_fetch_subscriptions_total{result="ok"} 1` + "\n", "srrb_fetch_last_run_success 0\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics miss %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestFetchPushesMetrics(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, Store: dir}
+	feed := serveFeed(t, rssItems("a", "b"))
+	pg, srv := newPushgateway(t)
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: feed.URL})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	// Counters and histograms accumulate over runs through db.json.
+	for run, wants := range [][]string{{
+		"# TYPE srrb_fetch_subscriptions_total counter\n",
+		`srrb_fetch_subscriptions_total{result="ok"} 1` + "\n",
+		"srrb_fetch_items_added_total 2\n",
+		`srrb_fetch_phase_duration_seconds_count{phase="download"} 1` + "\n",
+		`srrb_fetch_phase_duration_seconds_count{phase="upload"} 1` + "\n",
+		"srrb_fetch_last_run_timestamp_seconds",
+		"srrb_fetch_last_run_success 1\n",
+	}, {
+		`srrb_fetch_subscriptions_total{result="ok"} 2` + "\n",
+		"srrb_fetch_items_added_total 2\n",
+		`srrb_fetch_phase_duration_seconds_count{phase="download"} 2` + "\n",
+	}} {
+		if err := (&FetchCmd{All: true, MetricsPush: srv.URL}).Run(); err != nil {
+			t.Fatalf("Run %d: %v", run+1, err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(pg.body, want) {
+				t.Errorf("run %d: pushed metrics miss %s:\n%s", run+1, want, pg.body)
+			}
+		}
+	}
+}
@@ -11,13 +11,51 @@ import (
 	"time"
 )
 
+// Attachment is a media file carried by an item, such as a podcast episode
+// or a video. Length is in bytes and Duration in seconds.
+type Attachment struct {
+	URL       string `json:"url"`
+	Type      string `json:"type,omitempty"`
+	Length    int64  `json:"length,omitempty"`
+	Duration  int    `json:"duration,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// Podcast is the episode metadata of podcast feeds, from the iTunes and
+// Podcasting 2.0 namespaces. Summary is HTML and Duration is in seconds.
+type Podcast struct {
+	Summary     string       `json:"summary,omitempty"`
+	Image       string       `json:"image,omitempty"`
+	Duration    int          `json:"duration,omitempty"`
+	Season      int          `json:"season,omitempty"`
+	Episode     int          `json:"episode,omitempty"`
+	EpisodeType string       `json:"episode_type,omitempty"`
+	Explicit    bool         `json:"explicit,omitempty"`
+	Transcripts []Transcript `json:"transcripts,omitempty"`
+}
+
+// Transcript is a transcript or captions file of a podcast episode.
+type Transcript struct {
+	URL      string `json:"url"`
+	Type     string `json:"type,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
 type RawItem struct {
-	GUID      uint32     `json:"guid"`
-	Title     string     `json:"title"`
-	Content   string     `json:"content"`
-	Link      string     `json:"link"`
-	Published *time.Time `json:"published"`
-	Raw       any        `json:"raw"`
+	GUID        uint32       `json:"guid"`
+	Title       string       `json:"title"`
+	Content     string       `json:"content"`
+	Link        string       `json:"link"`
+	Published   *time.Time   `json:"published"` // nil if the feed gives no date
+	Author      string       `json:"author,omitempty"`
+	Categories  []string     `json:"categories,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Podcast     *Podcast     `json:"podcast,omitempty"`
+	Raw         any          `json:"raw"`
+
+	// Key identifies the item for duplicate detection. It is wider than
+	// GUID and not exposed to modules.
+	Key uint64 `json:"-"`
 }
 
 var registry = map[string]func() func(*RawItem) error{}
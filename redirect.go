@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// movedTo returns where the leading permanent redirects (301, 308) that res
+// went through point to, or "" when the request sent was not permanently
+// redirected. Hops after a temporary redirect do not count.
+func movedTo(res *http.Response) string {
+	var hops []*http.Request
+	for req := res.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops = append(hops, req)
+	}
+
+	var moved string
+	for i := len(hops) - 1; i >= 0; i-- {
+		switch hops[i].Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			moved = hops[i].URL.String()
+			continue
+		}
+		break
+	}
+	return moved
+}
+
+// redirected records that a successful fetch of s was permanently
+// redirected to moved, "" if it was not. Once the same redirect was seen on
+// the configured number of fetches in a row, it becomes the subscription
+// url.
+func (s *Subscription) redirected(moved string) {
+	switch {
+	case moved == "" || moved == s.URL:
+		s.MovedTo, s.MovedSeen = "", 0
+		return
+	case moved == s.MovedTo:
+		s.MovedSeen++
+	default:
+		s.MovedTo, s.MovedSeen = moved, 1
+	}
+
+	if globals.RedirectAfter > 0 && s.MovedSeen >= globals.RedirectAfter {
+		slog.Info("subscription moved", "sub", s, "to", moved)
+		s.URL = moved
+		s.MovedTo, s.MovedSeen = "", 0
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	redirect := func(path, to string, code int) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, to, code)
+		})
+	}
+	redirect("/old", "/new", http.StatusMovedPermanently)
+	redirect("/older", "/old", http.StatusPermanentRedirect)
+	redirect("/tmp", "/new", http.StatusFound)
+	redirect("/via-tmp", "/tmp", http.StatusMovedPermanently)
+	redirect("/tmp-first", "/old", http.StatusTemporaryRedirect)
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rssItems("a")))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMovedTo(t *testing.T) {
+	srv := redirectServer(t)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/new", ""},
+		{"/old", "/new"},
+		{"/older", "/new"},
+		{"/tmp", ""},
+		{"/via-tmp", "/tmp"},
+		{"/tmp-first", ""},
+	}
+	for _, tt := range tests {
+		res, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		want := tt.want
+		if want != "" {
+			want = srv.URL + want
+		}
+		if got := movedTo(res); got != want {
+			t.Errorf("movedTo(%s) = %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestFetchFollowsPermanentRedirect(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100, RedirectAfter: 2}
+	srv := redirectServer(t)
+	// As fetch runs do after a successful fetch.
+	fetchMove := func(t *testing.T, s *Subscription) {
+		t.Helper()
+		fetchSub(t, s)
+		s.redirected(s.stats.MovedTo)
+	}
+
+	s := &Subscription{URL: srv.URL + "/old"}
+	fetchMove(t, s)
+	if s.URL != srv.URL+"/old" || s.MovedSeen != 1 {
+		t.Fatalf("after 1 fetch: url = %s, seen = %d", s.URL, s.MovedSeen)
+	}
+	fetchMove(t, s)
+	if s.URL != srv.URL+"/new" || s.MovedTo != "" || s.MovedSeen != 0 {
+		t.Fatalf("after 2 fetches: url = %s, moved = %q %d", s.URL, s.MovedTo, s.MovedSeen)
+	}
+
+	s = &Subscription{URL: srv.URL + "/tmp"}
+	for range 3 {
+		fetchMove(t, s)
+	}
+	if s.URL != srv.URL+"/tmp" || s.MovedTo != "" {
+		t.Errorf("temporary redirect: url = %s, moved = %q", s.URL, s.MovedTo)
+	}
+
+	globals.RedirectAfter = 0
+	s = &Subscription{URL: srv.URL + "/old"}
+	for range 3 {
+		fetchMove(t, s)
+	}
+	if s.URL != srv.URL+"/old" {
+		t.Errorf("moved with redirects disabled: url = %s", s.URL)
+	}
+}
+
+func TestFetchMovedSubReleasesHost(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, RedirectAfter: 1, Store: dir}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, srv.URL+"/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte(rssItems(r.URL.Path)))
+	}))
+	t.Cleanup(srv.Close)
+	// The subscriptions start on localhost and the moved one ends up on
+	// 127.0.0.1, another host for the queue.
+	local := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "Moved", URL: local + "/old"})
+	db.AddSubscription(&Subscription{Title: "A", URL: local + "/a"})
+	db.AddSubscription(&Subscription{Title: "B", URL: local + "/b"})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	done := make(chan error, 1)
+	go func() { done <- (&FetchCmd{}).Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetch hung after a subscription moved host")
+	}
+
+	db, err = NewDB(ctx, false)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close(ctx)
+	subs := db.Subscriptions()
+	if subs[0].URL != srv.URL+"/new" {
+		t.Errorf("moved url = %s", subs[0].URL)
+	}
+	for _, s := range subs {
+		if s.TotalArticles != 1 {
+			t.Errorf("%s: %d articles, want 1", s.Title, s.TotalArticles)
+		}
+	}
+}
+
+func TestDryRunKeepsRedirectCount(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, RedirectAfter: 2, Store: dir}
+	srv := redirectServer(t)
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: srv.URL + "/old", MovedTo: srv.URL + "/new", MovedSeen: 1})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	report := filepath.Join(t.TempDir(), "report.json")
+	if err := (&FetchCmd{DryRun: true, Report: report}).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r fetchReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Subscriptions) != 1 || r.Subscriptions[0].URL != srv.URL+"/old" {
+		t.Errorf("dry run report = %s, want the subscription at /old", data)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fetchResult is the outcome of fetching one subscription in a run.
+type fetchResult struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	Health      string   `json:"health"`
+	Status      int      `json:"status,omitempty"`
+	NotModified bool     `json:"not_modified,omitempty"`
+	Bytes       int64    `json:"bytes"`
+	DurationMS  int64    `json:"duration_ms"`
+	Parsed      int      `json:"parsed"`
+	Added       int      `json:"added"`
+	PipelineMS  int64    `json:"pipeline_ms"`
+	New         []string `json:"new,omitempty"` // titles of the added items, dry runs only
+	Error       string   `json:"error,omitempty"`
+}
+
+// newFetchResult reports the fetch of s that took d and failed with err, if
+// not nil. titles lists the new items by title.
+func newFetchResult(s *Subscription, err error, d time.Duration, titles bool) *fetchResult {
+	r := &fetchResult{
+		ID:          s.ID,
+		Title:       s.Title,
+		URL:         s.URL,
+		Health:      s.health(),
+		Status:      s.stats.Status,
+		NotModified: s.stats.NotModified,
+		Bytes:       s.stats.Bytes,
+		DurationMS:  d.Milliseconds(),
+		Parsed:      s.stats.Parsed,
+		Added:       len(s.newItems),
+		PipelineMS:  s.stats.Pipeline.Milliseconds(),
+	}
+	if titles {
+		for _, it := range s.newItems {
+			r.New = append(r.New, it.Title)
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// fetchReport summarizes a fetch run.
+type fetchReport struct {
+	StartedAt     time.Time      `json:"started_at"`
+	DurationMS    int64          `json:"duration_ms"`
+	DryRun        bool           `json:"dry_run,omitempty"`
+	Fetched       int            `json:"fetched"`
+	Failed        int            `json:"failed"`
+	NotModified   int            `json:"not_modified"`
+	Added         int            `json:"added"`
+	Bytes         int64          `json:"bytes"`
+	Packs         []string       `json:"packs"`
+	Subscriptions []*fetchResult `json:"subscriptions"`
+}
+
+func newFetchReport(start time.Time, dryRun bool, results []*fetchResult, packs []string) *fetchReport {
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	r := &fetchReport{
+		StartedAt:     start.UTC(),
+		DurationMS:    time.Since(start).Milliseconds(),
+		DryRun:        dryRun,
+		Packs:         append([]string{}, packs...),
+		Subscriptions: append([]*fetchResult{}, results...),
+	}
+	for _, s := range results {
+		r.Fetched++
+		r.Added += s.Added
+		r.Bytes += s.Bytes
+		if s.Error != "" {
+			r.Failed++
+		}
+		if s.NotModified {
+			r.NotModified++
+		}
+	}
+	return r
+}
+
+// printText prints the subscriptions of r with their new items.
+func (r *fetchReport) printText() {
+	for _, s := range r.Subscriptions {
+		fmt.Printf("[%d] %s: %d new\n", s.ID, s.Title, s.Added)
+		for _, t := range s.New {
+			fmt.Printf("  + %s\n", t)
+		}
+		if s.Error != "" {
+			fmt.Printf("  ! %s\n", s.Error)
+		}
+	}
+}
+
+// checkReportKey rejects store keys a report must not be written to: those
+// of the database and its packs, or keys not in plain relative form.
+func checkReportKey(key string) error {
+	if key == "" || key != path.Clean(key) || path.IsAbs(key) || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("report key %q must be a relative path in the store", key)
+	}
+	if key == dbFileKey || key == dbLockKey {
+		return fmt.Errorf("report key %q is used by the database", key)
+	}
+	for _, prefix := range []string{"idx/", "data/", "ts/"} {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Errorf("report key %q is in the %s packs", key, strings.TrimSuffix(prefix, "/"))
+		}
+	}
+	return nil
+}
+
+// write stores r as JSON at dest: "-" for stdout, store:KEY for a key of
+// the store, or else a file path.
+func (r *fetchReport) write(ctx context.Context, db *DB, dest string) error {
+	data, err := jsonEncode(r)
+	if err != nil {
+		return err
+	}
+	if key, ok := strings.CutPrefix(dest, "store:"); ok {
+		return db.AtomicPut(ctx, key, data)
+	}
+	if dest == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(dest, data, 0o644)
+}
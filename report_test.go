@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewFetchResult(t *testing.T) {
+	s := &Subscription{ID: 2, Title: "T", URL: "u", newItems: []*Item{{Title: "a"}, {Title: "b"}}}
+	s.stats = fetchStats{Status: 200, Bytes: 10, Parsed: 3, Pipeline: 5 * time.Millisecond}
+
+	r := newFetchResult(s, nil, time.Second, true)
+	if r.Added != 2 || !slices.Equal(r.New, []string{"a", "b"}) || r.Status != 200 || r.Bytes != 10 ||
+		r.Parsed != 3 || r.PipelineMS != 5 || r.DurationMS != 1000 || r.Health != healthOK || r.Error != "" {
+		t.Errorf("result = %+v", r)
+	}
+	if r = newFetchResult(s, nil, time.Second, false); r.New != nil {
+		t.Errorf("titles listed outside dry runs: %v", r.New)
+	}
+	if r = newFetchResult(&Subscription{ID: 3}, errors.New("boom"), 0, true); r.Error != "boom" {
+		t.Errorf("failed result = %+v", r)
+	}
+}
+
+func TestNewFetchReport(t *testing.T) {
+	results := []*fetchResult{
+		{ID: 3, Added: 2, Bytes: 100},
+		{ID: 1, NotModified: true},
+		{ID: 2, Error: "boom", Bytes: 5},
+	}
+	r := newFetchReport(time.Now(), false, results, []string{"idx/true.gz"})
+	if r.Fetched != 3 || r.Failed != 1 || r.NotModified != 1 || r.Added != 2 || r.Bytes != 105 {
+		t.Errorf("totals = %+v", r)
+	}
+	if r.Subscriptions[0].ID != 1 || r.Subscriptions[2].ID != 3 {
+		t.Error("subscriptions not sorted by id")
+	}
+	if !slices.Equal(r.Packs, []string{"idx/true.gz"}) {
+		t.Errorf("packs = %v", r.Packs)
+	}
+}
+
+func TestFetchStats(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+	body := rssItems("a", "b", "a")
+	srv := serveFeed(t, body)
+
+	s := &Subscription{URL: srv.URL, Seen: seenSet{hash64("b")}}
+	fetchSub(t, s)
+	if s.stats.Status != http.StatusOK || s.stats.Bytes != int64(len(body)) || s.stats.Parsed != 2 || s.stats.NotModified {
+		t.Errorf("stats = %+v", s.stats)
+	}
+}
+
+func TestFetchWritesReport(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, Store: dir}
+	srv := serveFeed(t, rssItems("a", "b"))
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: srv.URL})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	if err := (&FetchCmd{Report: "store:status.json"}).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r fetchReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Fetched != 1 || r.Added != 2 || len(r.Subscriptions) != 1 || r.Subscriptions[0].Status != http.StatusOK {
+		t.Errorf("report = %s", data)
+	}
+	if !slices.Contains(r.Packs, "idx/true.gz") || !slices.Contains(r.Packs, "data/true.gz") {
+		t.Errorf("packs = %v", r.Packs)
+	}
+
+	if err := (&FetchCmd{DryRun: true, Report: "store:status.json"}).Run(); err == nil {
+		t.Error("dry run wrote its report to the store")
+	}
+	if err := (&FetchCmd{Report: "store:db.json"}).Run(); err == nil {
+		t.Error("report written over the database")
+	}
+}
+
+func TestCheckReportKey(t *testing.T) {
+	for _, key := range []string{"status.json", "reports/status.json", "idx.json"} {
+		if err := checkReportKey(key); err != nil {
+			t.Errorf("checkReportKey(%q) = %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "db.json", ".locked", "idx/true.gz", "data/3.gz", "ts/false.gz", "./db.json", "/status.json", "../status.json", "a//b"} {
+		if err := checkReportKey(key); err == nil {
+			t.Errorf("checkReportKey(%q) accepted", key)
+		}
+	}
+}
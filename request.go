@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gllera/srrb/backend"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// secrets holds the credentials of the "secrets" section of the config
+// file, referenced by subscriptions as secret:NAME.
+var secrets = map[string]string{}
+
+func init() {
+	backend.RegisterConfig("secrets", &secrets)
+}
+
+// HTTPOptions are the request settings of a subscription. Credentials are
+// never stored: Secret and header values may be references resolved when
+// the request is made (see resolveSecret).
+type HTTPOptions struct {
+	Headers   map[string]string `json:"headers,omitempty"`
+	UserAgent string            `json:"ua,omitempty"`
+	Timeout   int               `json:"timeout,omitempty"` // seconds
+	Auth      string            `json:"auth,omitempty"`    // "basic" or "bearer"
+	Secret    string            `json:"secret,omitempty"`
+}
+
+func isSecretRef(s string) bool {
+	return strings.HasPrefix(s, "env:") || strings.HasPrefix(s, "secret:")
+}
+
+// isCredentialHeader reports whether the header name carries credentials,
+// whose value must then be a secret reference.
+func isCredentialHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	switch name {
+	case "Authorization", "Proxy-Authorization", "Cookie":
+		return true
+	}
+	return strings.HasSuffix(name, "-Token") || strings.HasSuffix(name, "-Key")
+}
+
+// resolveSecret returns the value of a secret reference: env:NAME reads an
+// environment variable and secret:NAME an entry of the config file secrets.
+// Other values are returned as they are.
+func resolveSecret(ref string) (string, error) {
+	switch kind, name, _ := strings.Cut(ref, ":"); kind {
+	case "env":
+		if v, ok := os.LookupEnv(name); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("environment variable %s not set", name)
+	case "secret":
+		if v, ok := secrets[name]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("secret %q not found in config", name)
+	}
+	return ref, nil
+}
+
+// validate reports options that could never make a valid request.
+func (o *HTTPOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Auth {
+	case "":
+	case "basic", "bearer":
+		if !isSecretRef(o.Secret) {
+			return fmt.Errorf("%s auth needs a secret given as env:NAME or secret:NAME", o.Auth)
+		}
+	default:
+		return fmt.Errorf("unknown auth %q, want basic or bearer", o.Auth)
+	}
+	for k, v := range o.Headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") {
+			return fmt.Errorf("invalid header name %q", k)
+		}
+		if isCredentialHeader(k) && !isSecretRef(v) {
+			return fmt.Errorf("header %s needs a value given as env:NAME or secret:NAME", k)
+		}
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	return nil
+}
+
+// client returns c with the options timeout, if any.
+func (o *HTTPOptions) client(c *http.Client) *http.Client {
+	if o == nil || o.Timeout == 0 {
+		return c
+	}
+	cc := *c
+	cc.Timeout = time.Duration(o.Timeout) * time.Second
+	return &cc
+}
+
+// HTTPFlags are the command line flags editing HTTPOptions.
+type HTTPFlags struct {
+	Header    *[]string `short:"H" optional:"" sep:"none" help:"Extra request header as \"Name: value\", value possibly a secret reference, required for Authorization, Cookie, *-Token and *-Key. Empty (\"\") to clear."`
+	UserAgent *string   `          optional:"" help:"User agent to send instead of SRRB's own."`
+	Timeout   *int      `          optional:"" help:"Request timeout in seconds. 0 for the default."`
+	Auth      *string   `          optional:"" help:"Authentication scheme: basic or bearer. Empty (\"\") to clear."`
+	Secret    *string   `          optional:"" help:"Auth credentials as env:NAME or secret:NAME (secrets config section); user:password for basic."`
+}
+
+// apply returns o updated with the flags given, or nil when no option
+// remains set.
+func (f *HTTPFlags) apply(o *HTTPOptions) (*HTTPOptions, error) {
+	var opts HTTPOptions
+	if o != nil {
+		opts = *o
+	}
+	if f.Header != nil {
+		opts.Headers = nil
+		for _, h := range *f.Header {
+			if h == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid header %q, want \"Name: value\"", h)
+			}
+			if opts.Headers == nil {
+				opts.Headers = map[string]string{}
+			}
+			opts.Headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+	}
+	if f.UserAgent != nil {
+		opts.UserAgent = *f.UserAgent
+	}
+	if f.Timeout != nil {
+		opts.Timeout = *f.Timeout
+	}
+	if f.Auth != nil {
+		opts.Auth = *f.Auth
+		if opts.Auth == "" {
+			opts.Secret = ""
+		}
+	}
+	if f.Secret != nil {
+		opts.Secret = *f.Secret
+	}
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Headers) == 0 && opts.UserAgent == "" && opts.Timeout == 0 && opts.Auth == "" && opts.Secret == "" {
+		return nil, nil
+	}
+	return &opts, nil
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultTimeout}
+}
+
+// newRequest builds a GET request for url with the options o, which may be
+// nil, applied.
+func newRequest(ctx context.Context, url string, o *HTTPOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "SRRB/"+version)
+	if o == nil {
+		return req, nil
+	}
+
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	for k, v := range o.Headers {
+		v, err := resolveSecret(v)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		req.Header.Set(k, v)
+	}
+
+	if o.Auth != "" {
+		secret, err := resolveSecret(o.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s auth: %w", o.Auth, err)
+		}
+		switch o.Auth {
+		case "basic":
+			user, pass, _ := strings.Cut(secret, ":")
+			req.SetBasicAuth(user, pass)
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+	}
+	return req, nil
+}
+
+// statusError is the error of a response with an unexpected HTTP status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected HTTP status: " + e.status
+}
+
+// retryable reports whether a request that failed with err, or got res,
+// may succeed if tried again.
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return dnsErr.IsTemporary || dnsErr.IsTimeout
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true
+		}
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// backoff returns the wait before retry number attempt, counting from 0:
+// the base backoff doubled on each attempt, capped at the max wait, of
+// which a random half is taken off to spread retries of many feeds.
+func backoff(attempt int) time.Duration {
+	d := min(globals.RetryBackoff<<attempt, globals.RetryMaxWait)
+	if d <= 0 {
+		d = globals.RetryMaxWait
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// doRequest sends req, retrying transient failures up to the configured
+// number of times. A Retry-After longer than the max wait is not waited
+// for: the failed response is returned instead. Request bodies are sent
+// again from req.GetBody.
+func doRequest(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		res, err := client.Do(req)
+		if attempt >= globals.Retries || !retryable(res, err) {
+			return res, err
+		}
+
+		wait := backoff(attempt)
+		if res != nil {
+			if d, ok := retryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				if d > globals.RetryMaxWait {
+					return res, nil
+				}
+				wait = d
+			}
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+			res.Body.Close()
+			err = &statusError{res.StatusCode, res.Status}
+		}
+		slog.Warn("retrying request", "url", req.URL, "attempt", attempt+1, "wait", wait, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gllera/srrb/mod"
+)
+
+func TestNewRequestOptions(t *testing.T) {
+	t.Setenv("SRR_TEST_TOKEN", "tok")
+	secrets = map[string]string{"jira": "ann:pw"}
+	t.Cleanup(func() { secrets = map[string]string{} })
+
+	tests := []struct {
+		name    string
+		opts    *HTTPOptions
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"default agent", nil, "User-Agent", "SRRB/" + version, false},
+		{"custom agent", &HTTPOptions{UserAgent: "Mozilla/5.0"}, "User-Agent", "Mozilla/5.0", false},
+		{"header", &HTTPOptions{Headers: map[string]string{"Accept-Language": "es"}}, "Accept-Language", "es", false},
+		{"header secret", &HTTPOptions{Headers: map[string]string{"Private-Token": "env:SRR_TEST_TOKEN"}}, "Private-Token", "tok", false},
+		{"bearer", &HTTPOptions{Auth: "bearer", Secret: "env:SRR_TEST_TOKEN"}, "Authorization", "Bearer tok", false},
+		{"basic", &HTTPOptions{Auth: "basic", Secret: "secret:jira"}, "Authorization", "Basic YW5uOnB3", false},
+		{"missing env", &HTTPOptions{Auth: "bearer", Secret: "env:SRR_TEST_MISSING"}, "", "", true},
+		{"missing secret", &HTTPOptions{Auth: "basic", Secret: "secret:nope"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := newRequest(context.Background(), "http://example.com/", tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPFlagsApply(t *testing.T) {
+	str := func(s string) *string { return &s }
+	headers := []string{"x-api-key: env:KEY", "Accept: a, b"}
+
+	opts, err := (&HTTPFlags{Header: &headers, Auth: str("bearer"), Secret: str("env:TOKEN")}).apply(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Headers["X-Api-Key"] != "env:KEY" || opts.Headers["Accept"] != "a, b" {
+		t.Errorf("headers = %v", opts.Headers)
+	}
+
+	// Unchanged options are kept, clearing everything yields nil.
+	timeout := 60
+	opts, err = (&HTTPFlags{Timeout: &timeout}).apply(opts)
+	if err != nil || opts.Timeout != 60 || opts.Auth != "bearer" {
+		t.Fatalf("opts = %+v, %v", opts, err)
+	}
+	none, zero := []string{""}, 0
+	opts, err = (&HTTPFlags{Header: &none, Auth: str(""), Timeout: &zero}).apply(opts)
+	if err != nil || opts != nil {
+		t.Errorf("opts = %+v, %v, want nil", opts, err)
+	}
+
+	for _, f := range []HTTPFlags{
+		{Auth: str("digest"), Secret: str("env:X")},
+		{Auth: str("bearer"), Secret: str("plain-token")},
+		{Header: &[]string{"no colon"}},
+		{Header: &[]string{"Authorization: Bearer abc"}},
+		{Header: &[]string{"cookie: session=abc"}},
+		{Header: &[]string{"X-Auth-Token: abc"}},
+		{Timeout: new(-1)},
+	} {
+		if _, err := f.apply(nil); err == nil {
+			t.Errorf("apply(%+v): expected error", f)
+		}
+	}
+}
+
+func TestFetchSendsOptions(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+	t.Setenv("SRR_TEST_TOKEN", "tok")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" || r.UserAgent() != "Browser" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(rssItems("a")))
+	}))
+	defer srv.Close()
+
+	s := &Subscription{URL: srv.URL, HTTP: &HTTPOptions{UserAgent: "Browser", Auth: "bearer", Secret: "env:SRR_TEST_TOKEN", Timeout: 30}}
+	fetchSub(t, s)
+	if len(s.newItems) != 1 {
+		t.Errorf("new items = %d, want 1", len(s.newItems))
+	}
+}
+
+// flakyServer fails its first failures requests with fail, then serves a
+// one item feed. It counts the requests received in *hits.
+func flakyServer(t *testing.T, failures int, fail func(w http.ResponseWriter), hits *int) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*hits++
+		n := *hits
+		mu.Unlock()
+		if n <= failures {
+			fail(w)
+			return
+		}
+		w.Write([]byte(rssItems("a")))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func failStatus(status int, retryAfter string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	hangUp := func(w http.ResponseWriter) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}
+	stall := func(w http.ResponseWriter) {
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		fail     func(http.ResponseWriter)
+		wantHits int
+		wantErr  bool
+	}{
+		{"503 then ok", 2, failStatus(http.StatusServiceUnavailable, ""), 3, false},
+		{"502 and 504", 1, failStatus(http.StatusBadGateway, ""), 2, false},
+		{"gives up", 5, failStatus(http.StatusGatewayTimeout, ""), 3, true},
+		{"not found is permanent", 1, failStatus(http.StatusNotFound, ""), 1, true},
+		{"server error is permanent", 1, failStatus(http.StatusInternalServerError, ""), 1, true},
+		{"retry after", 1, failStatus(http.StatusTooManyRequests, "0"), 2, false},
+		{"retry after too long", 1, failStatus(http.StatusTooManyRequests, "3600"), 1, true},
+		{"connection reset", 1, hangUp, 2, false},
+		{"timeout", 1, stall, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globals = &Globals{MaxFeedSize: 100, SeenWindow: 100, Retries: 2, RetryBackoff: time.Millisecond, RetryMaxWait: time.Second}
+			var hits int
+			srv := flakyServer(t, tt.failures, tt.fail, &hits)
+
+			s := &Subscription{URL: srv.URL}
+			client := &http.Client{Timeout: 100 * time.Millisecond}
+			err := s.Fetch(context.Background(), client, mod.New())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("requests = %d, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
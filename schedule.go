@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dueSlack lets a fetch run take subscriptions due shortly after it, so a
+// cron schedule slightly ahead of the due times does not skip a run.
+const dueSlack = time.Minute
+
+// postingSample is how many of the newest items estimate how often a feed
+// posts.
+const postingSample = 20
+
+// due reports whether s should be fetched by a run at now.
+func (s *Subscription) due(now time.Time) bool {
+	return s.NextFetch <= now.Add(dueSlack).Unix()
+}
+
+// feedTTL returns the update interval a feed header announces through
+// <ttl> (minutes) or sy:updatePeriod and sy:updateFrequency.
+func feedTTL(r rawFeedItem) time.Duration {
+	var ttl time.Duration
+	if m := parseInt(r.text("ttl")); m > 0 {
+		ttl = time.Duration(m) * time.Minute
+	}
+
+	periods := map[string]time.Duration{
+		"hourly":  time.Hour,
+		"daily":   24 * time.Hour,
+		"weekly":  7 * 24 * time.Hour,
+		"monthly": 30 * 24 * time.Hour,
+		"yearly":  365 * 24 * time.Hour,
+	}
+	if p, ok := periods[strings.ToLower(r.text("sy:updatePeriod"))]; ok {
+		freq := max(parseInt(r.text("sy:updateFrequency")), 1)
+		ttl = max(ttl, p/time.Duration(freq))
+	}
+	return ttl
+}
+
+// cacheTTL returns for how long a response is fresh according to its
+// Cache-Control max-age or its Expires header.
+func cacheTTL(h http.Header, now time.Time) time.Duration {
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age":
+			if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if exp, err := http.ParseTime(h.Get("Expires")); err == nil {
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			now = date
+		}
+		return max(exp.Sub(now), 0)
+	}
+	return 0
+}
+
+// postingInterval estimates how often a feed posts from the dates of its
+// items: the mean gap between the newest ones, or the age of the newest if
+// the feed has gone quiet for longer. It is 0 without two dated items.
+func postingInterval(posted []time.Time, now time.Time) time.Duration {
+	posted = slices.DeleteFunc(slices.Clone(posted), func(t time.Time) bool {
+		return t.After(now)
+	})
+	if len(posted) < 2 {
+		return 0
+	}
+	slices.SortFunc(posted, func(a, b time.Time) int { return b.Compare(a) })
+	posted = posted[:min(len(posted), postingSample)]
+
+	gap := posted[0].Sub(posted[len(posted)-1]) / time.Duration(len(posted)-1)
+	return max(gap, now.Sub(posted[0]))
+}
+
+// schedule sets when s is next due after a successful fetch at now. The
+// feed is polled twice per posting interval, when known, but never sooner
+// than the publisher asks through ttl, nor outside the configured bounds.
+// Without a posting interval, as on 304 responses, the last interval is
+// kept.
+func (s *Subscription) schedule(now time.Time, ttl, posting time.Duration) {
+	lo := max(globals.MinInterval, time.Duration(s.MinInterval)*time.Second)
+	hi := max(globals.MaxInterval, lo)
+
+	interval := time.Duration(s.Interval) * time.Second
+	if posting > 0 {
+		interval = posting / 2
+	}
+	interval = min(max(interval, ttl, lo), hi)
+
+	s.Interval = int64(interval / time.Second)
+	s.NextFetch = now.Add(interval).Unix()
+}
+
+// retryLater sets when s is next due after its latest consecutive failure
+// at now: the min interval, doubled on each further failure up to the max
+// one, so an outage takes many runs before the subscription is paused. The
+// interval of successful fetches is kept.
+func (s *Subscription) retryLater(now time.Time) {
+	lo := max(globals.MinInterval, time.Duration(s.MinInterval)*time.Second)
+	hi := max(globals.MaxInterval, lo)
+
+	wait := lo
+	for range s.Failures - 1 {
+		if wait >= hi {
+			break
+		}
+		wait *= 2
+	}
+	s.NextFetch = now.Add(min(wait, hi)).Unix()
+}
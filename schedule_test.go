@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFeedTTL(t *testing.T) {
+	tests := []struct {
+		feed string
+		want time.Duration
+	}{
+		{`<rss><channel><title>T</title></channel></rss>`, 0},
+		{`<rss><channel><ttl>60</ttl></channel></rss>`, time.Hour},
+		{`<rss xmlns:sy="http://purl.org/rss/1.0/modules/syndication/"><channel>
+		  <sy:updatePeriod>daily</sy:updatePeriod><sy:updateFrequency>4</sy:updateFrequency>
+		</channel></rss>`, 6 * time.Hour},
+		{`<rss xmlns:sy="http://purl.org/rss/1.0/modules/syndication/"><channel>
+		  <ttl>30</ttl><sy:updatePeriod>hourly</sy:updatePeriod>
+		</channel></rss>`, time.Hour},
+	}
+	for _, tt := range tests {
+		doc := &feedDoc{}
+		collectFeedDoc(t, tt.feed, doc)
+		if doc.TTL != tt.want {
+			t.Errorf("TTL = %v, want %v for %s", doc.TTL, tt.want, tt.feed)
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		headers map[string]string
+		want    time.Duration
+	}{
+		{nil, 0},
+		{map[string]string{"Cache-Control": "public, max-age=3600"}, time.Hour},
+		{map[string]string{"Cache-Control": "no-cache, max-age=3600"}, 0},
+		{map[string]string{"Expires": "Mon, 01 Jan 2024 12:30:00 GMT"}, 30 * time.Minute},
+		{map[string]string{"Expires": "Mon, 01 Jan 2024 12:30:00 GMT", "Date": "Mon, 01 Jan 2024 12:20:00 GMT"}, 10 * time.Minute},
+		{map[string]string{"Expires": "0"}, 0},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		for k, v := range tt.headers {
+			h.Set(k, v)
+		}
+		if got := cacheTTL(h, now); got != tt.want {
+			t.Errorf("cacheTTL(%v) = %v, want %v", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestPostingInterval(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	tests := []struct {
+		name   string
+		posted []time.Time
+		want   time.Duration
+	}{
+		{"none", nil, 0},
+		{"single", []time.Time{ago(time.Hour)}, 0},
+		{"hourly", []time.Time{ago(time.Hour), ago(2 * time.Hour), ago(3 * time.Hour)}, time.Hour},
+		{"unordered", []time.Time{ago(3 * time.Hour), ago(time.Hour), ago(2 * time.Hour)}, time.Hour},
+		{"gone quiet", []time.Time{ago(72 * time.Hour), ago(73 * time.Hour)}, 72 * time.Hour},
+		{"future ignored", []time.Time{now.Add(time.Hour), ago(time.Hour), ago(2 * time.Hour)}, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := postingInterval(tt.posted, now); got != tt.want {
+			t.Errorf("%s: postingInterval = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	globals = &Globals{MinInterval: 15 * time.Minute, MaxInterval: 24 * time.Hour}
+	now := time.Unix(1_000_000, 0)
+
+	tests := []struct {
+		name     string
+		sub      Subscription
+		ttl      time.Duration
+		posting  time.Duration
+		interval time.Duration
+	}{
+		{"unknown", Subscription{}, 0, 0, 15 * time.Minute},
+		{"daily poster", Subscription{}, 0, 24 * time.Hour, 12 * time.Hour},
+		{"busy poster", Subscription{}, 0, 5 * time.Minute, 15 * time.Minute},
+		{"ttl", Subscription{}, 2 * time.Hour, 30 * time.Minute, 2 * time.Hour},
+		{"dormant", Subscription{}, 0, 30 * 24 * time.Hour, 24 * time.Hour},
+		{"sub min", Subscription{MinInterval: 3600}, 0, 0, time.Hour},
+		{"keeps last", Subscription{Interval: 7200}, 0, 0, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		s := tt.sub
+		s.schedule(now, tt.ttl, tt.posting)
+		if got := time.Duration(s.Interval) * time.Second; got != tt.interval {
+			t.Errorf("%s: interval = %v, want %v", tt.name, got, tt.interval)
+		}
+		if s.NextFetch != now.Add(tt.interval).Unix() {
+			t.Errorf("%s: next = %d", tt.name, s.NextFetch)
+		}
+	}
+
+	s := Subscription{NextFetch: now.Add(30 * time.Second).Unix()}
+	if !s.due(now) {
+		t.Error("subscription due within the slack should be due")
+	}
+	s.NextFetch = now.Add(time.Hour).Unix()
+	if s.due(now) {
+		t.Error("subscription due in an hour should not be due")
+	}
+}
+
+func TestFetchSchedules(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100, MinInterval: time.Minute, MaxInterval: 24 * time.Hour}
+	srv := serveFeed(t, `<rss version="2.0"><channel><ttl>120</ttl><item><guid>a</guid></item></channel></rss>`)
+
+	s := &Subscription{URL: srv.URL}
+	before := time.Now()
+	fetchSub(t, s)
+	if s.Interval != 7200 || s.NextFetch < before.Add(2*time.Hour).Unix() {
+		t.Errorf("interval = %d, next = %d, want the feed ttl", s.Interval, s.NextFetch)
+	}
+}
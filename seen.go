@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// seenSet holds the identities of the items recently seen in a feed, in
+// feed order. It is stored in db.json as base64 of the big-endian hashes.
+type seenSet []uint64
+
+func (s seenSet) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 8*len(s))
+	for _, k := range s {
+		buf = binary.BigEndian.AppendUint64(buf, k)
+	}
+	return json.Marshal(base64.RawStdEncoding.EncodeToString(buf))
+}
+
+func (s *seenSet) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	buf, err := base64.RawStdEncoding.DecodeString(str)
+	if err != nil {
+		return fmt.Errorf("decoding seen items: %w", err)
+	}
+	if len(buf)%8 != 0 {
+		return fmt.Errorf("decoding seen items: truncated hash")
+	}
+	*s = make(seenSet, 0, len(buf)/8)
+	for i := 0; i < len(buf); i += 8 {
+		*s = append(*s, binary.BigEndian.Uint64(buf[i:]))
+	}
+	return nil
+}
+
+// merge returns the identities of the current feed followed by those of
+// s no longer in it, up to window entries. The current feed is always
+// kept whole, so a window smaller than the feed never causes re-adds.
+func (s seenSet) merge(current []uint64, window int) seenSet {
+	out := make(seenSet, 0, max(window, len(current)))
+	out = append(out, current...)
+
+	inFeed := make(map[uint64]bool, len(current))
+	for _, k := range current {
+		inFeed[k] = true
+	}
+	for _, k := range s {
+		if len(out) >= window {
+			break
+		}
+		if !inFeed[k] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"html"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 
@@ -23,13 +24,91 @@ func processItem(ctx context.Context, processor *mod.Module, pipeline []string,
 			return fmt.Errorf("module %q failed: %w", m, err)
 		}
 	}
-	i.Title = html.UnescapeString(titlePolicy.Sanitize(i.Title))
-	i.Title = strings.Join(strings.Fields(i.Title), " ")
+	i.Title = cleanTitle(i.Title)
 	i.Link = strings.Map(stripControl, i.Link)
 	i.Content = strings.Map(stripControlKeepWS, i.Content)
+	i.Author = strings.Join(strings.Fields(i.Author), " ")
+	for k, c := range i.Categories {
+		i.Categories[k] = strings.Join(strings.Fields(c), " ")
+	}
+	i.Categories = uniqueStrings(i.Categories)
+	i.Attachments = slices.DeleteFunc(i.Attachments, func(a mod.Attachment) bool {
+		return strings.Map(stripControl, a.URL) == ""
+	})
+	for k := range i.Attachments {
+		a := &i.Attachments[k]
+		a.URL = strings.Map(stripControl, a.URL)
+		a.Type = strings.Map(stripControl, a.Type)
+		a.Thumbnail = strings.Map(stripControl, a.Thumbnail)
+	}
+	if p := i.Podcast; p != nil {
+		p.Summary = strings.Map(stripControlKeepWS, p.Summary)
+		p.Image = strings.Map(stripControl, p.Image)
+		p.EpisodeType = strings.Map(stripControl, p.EpisodeType)
+		p.Transcripts = slices.DeleteFunc(p.Transcripts, func(t mod.Transcript) bool {
+			return strings.Map(stripControl, t.URL) == ""
+		})
+		for k := range p.Transcripts {
+			t := &p.Transcripts[k]
+			t.URL = strings.Map(stripControl, t.URL)
+			t.Type = strings.Map(stripControl, t.Type)
+			t.Language = strings.Map(stripControl, t.Language)
+		}
+	}
 	return nil
 }
 
+// newItem converts a processed feed item, found at position pos of its
+// feed, into an article of s.
+func newItem(s *Subscription, i *mod.RawItem, dates itemDates, pos int) *Item {
+	it := &Item{
+		Sub:         s,
+		Title:       i.Title,
+		Content:     i.Content,
+		Link:        i.Link,
+		Author:      i.Author,
+		Categories:  i.Categories,
+		Attachments: i.Attachments,
+		Podcast:     i.Podcast,
+	}
+	dates.set(it, i.Published, pos)
+	return it
+}
+
+// itemDates dates the articles of one feed document fetched at now.
+type itemDates struct {
+	doc *feedDoc
+	now time.Time
+}
+
+// set gives it the published date of its feed item. An undated item takes
+// the feed's own date, or else the fetch time, less its position in the
+// feed in seconds, so sorting by date keeps the feed order. Dates after the
+// fetch time are clamped to it. DateSource records either case.
+func (d itemDates) set(it *Item, published *time.Time, pos int) {
+	switch {
+	case published == nil:
+		base, source := d.doc.Date, "feed"
+		if base.IsZero() || base.After(d.now) {
+			base, source = d.now, "fetch"
+		}
+		it.Published = base.Unix() - int64(pos)
+		it.DateSource = source
+	case published.After(d.now):
+		it.Published = d.now.Unix()
+		it.OrigPublished = published.Unix()
+		it.DateSource = "future"
+	default:
+		it.Published = published.Unix()
+	}
+}
+
+// cleanTitle reduces an HTML title to plain text on a single line.
+func cleanTitle(s string) string {
+	s = html.UnescapeString(titlePolicy.Sanitize(s))
+	return strings.Join(strings.Fields(s), " ")
+}
+
 func stripControl(r rune) rune {
 	if r <= ' ' || r == 0x7f {
 		return -1
@@ -45,18 +124,32 @@ func stripControlKeepWS(r rune) rune {
 }
 
 type Subscription struct {
-	ID             int      `json:"id"`
-	Title          string   `json:"title"`
-	URL            string   `json:"url"`
-	Tag            string   `json:"tag,omitempty"`
-	Pipeline       []string `json:"pipe,omitempty"`
-	FetchError     string   `json:"ferr,omitempty"`
-	StopGUID       uint32   `json:"stop_guid,omitempty"`
-	ETag           string   `json:"etag,omitempty"`
-	LastModified   string   `json:"last_modified,omitempty"`
-	TotalArticles  int      `json:"total_art,omitempty"`
-	LastAddedAt    int64    `json:"last_added,omitempty"`
+	ID             int          `json:"id"`
+	Title          string       `json:"title"`
+	URL            string       `json:"url"`
+	Tag            string       `json:"tag,omitempty"`
+	Pipeline       []string     `json:"pipe,omitempty"`
+	DateLayout     string       `json:"date_fmt,omitempty"`
+	HTTP           *HTTPOptions `json:"http,omitempty"`
+	MinInterval    int          `json:"min_ival,omitempty"` // seconds, set by the user
+	Interval       int64        `json:"ival,omitempty"`     // seconds, see schedule
+	NextFetch      int64        `json:"next_fetch,omitempty"`
+	Feed           *FeedInfo    `json:"feed,omitempty"`
+	FetchError     string       `json:"ferr,omitempty"`
+	Failures       int          `json:"fails,omitempty"`      // consecutive failed fetches
+	FailingSince   int64        `json:"first_fail,omitempty"` // first of the current failures
+	LastSuccess    int64        `json:"last_ok,omitempty"`
+	MovedTo        string       `json:"moved_to,omitempty"` // permanent redirect target, see redirected
+	MovedSeen      int          `json:"moved_n,omitempty"`
+	Paused         bool         `json:"paused,omitempty"`
+	Seen           seenSet      `json:"seen,omitempty"`
+	StopGUID       uint32       `json:"stop_guid,omitempty"` // superseded by Seen, read for migration
+	ETag           string       `json:"etag,omitempty"`
+	LastModified   string       `json:"last_modified,omitempty"`
+	TotalArticles  int          `json:"total_art,omitempty"`
+	LastAddedAt    int64        `json:"last_added,omitempty"`
 	newItems       []*Item
+	stats          fetchStats
 	oTotalArticles int
 	oLastAddedAt   int64
 }
@@ -68,14 +161,59 @@ func (s Subscription) LogValue() slog.Value {
 	)
 }
 
-func (s *Subscription) Fetch(ctx context.Context, client *http.Client, buf []byte, processor *mod.Module) error {
+// sizeLimiter fails reads once more than max bytes came through.
+type sizeLimiter struct {
+	r    io.Reader
+	n    int64
+	max  int64
+	fail error
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if l.fail != nil {
+		return 0, l.fail
+	}
+	if room := l.max - l.n + 1; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := l.r.Read(p)
+	if l.n += int64(n); l.n > l.max {
+		l.fail = fmt.Errorf("feed bigger than %d bytes", l.max)
+		return 0, l.fail
+	}
+	return n, err
+}
+
+// fetchStats describes the last fetch of a subscription, for the run
+// report.
+type fetchStats struct {
+	Status      int
+	NotModified bool
+	Bytes       int64
+	Parsed      int
+	Download    time.Duration // until the response headers
+	Parse       time.Duration // reading and parsing the body, pipeline excluded
+	Pipeline    time.Duration
+	Failure     string // class of a failure past the request, see failureClass
+	MovedTo     string // permanent redirect target, see movedTo
+}
+
+// readFeed returns the body of a feed response for parseFeed, bounded by
+// the max feed size, along with what is known about the document.
+func readFeed(res *http.Response) (*sizeLimiter, *feedDoc) {
+	return &sizeLimiter{r: res.Body, max: int64(globals.MaxFeedSize) << 10}, &feedDoc{
+		URL:         res.Request.URL.String(),
+		ContentType: res.Header.Get("Content-Type"),
+	}
+}
+
+func (s *Subscription) Fetch(ctx context.Context, client *http.Client, processor *mod.Module) error {
 	slog.Debug("downloading subscription", "sub", s)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	req, err := newRequest(ctx, s.URL, s.HTTP)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", "SRRB/"+version)
 	if s.ETag != "" {
 		req.Header.Set("If-None-Match", s.ETag)
 	}
@@ -83,66 +221,110 @@ func (s *Subscription) Fetch(ctx context.Context, client *http.Client, buf []byt
 		req.Header.Set("If-Modified-Since", s.LastModified)
 	}
 
-	res, err := client.Do(req)
+	s.stats = fetchStats{}
+	began := time.Now()
+	res, err := doRequest(ctx, s.HTTP.client(client), req)
+	s.stats.Download = time.Since(began)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	s.stats.Status = res.StatusCode
 
+	now := time.Now()
 	if res.StatusCode == http.StatusNotModified {
+		s.stats.NotModified = true
 		slog.Debug("subscription not modified", "sub", s)
+		s.schedule(now, cacheTTL(res.Header, now), 0)
+		s.stats.MovedTo = movedTo(res)
 		return nil
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: %s", res.Status)
+		return &statusError{res.StatusCode, res.Status}
 	}
 
 	etag := res.Header.Get("ETag")
 	lastModified := res.Header.Get("Last-Modified")
 
-	n, err := io.ReadFull(res.Body, buf)
-	if err == nil {
-		return fmt.Errorf("subscription file bigger than %d bytes", cap(buf)-1)
-	}
-	if errors.Is(err, io.EOF) {
-		return fmt.Errorf("empty response from subscription")
+	body, doc := readFeed(res)
+	doc.DateLayout = s.DateLayout
+	dates := itemDates{doc: doc, now: now}
+	s.newItems = nil
+
+	seen := make(map[uint64]bool, len(s.Seen))
+	for _, k := range s.Seen {
+		seen[k] = true
 	}
-	if !errors.Is(err, io.ErrUnexpectedEOF) {
-		return err
+	// Subscriptions stored before Seen existed only know the top item of
+	// the last fetch: everything from it on is considered seen.
+	stopAt := s.StopGUID
+	if len(s.Seen) > 0 {
+		stopAt = 0
 	}
+	stopped := false
 
-	s.newItems = nil
-	var last *mod.RawItem
+	var current []uint64
+	var posted []time.Time
+	var fresh []*mod.RawItem
+	var freshPos []int
+	inFeed := make(map[uint64]bool)
 
-	err = parseFeed(buf[:n], func(i *mod.RawItem) error {
-		if last == nil {
-			last = i
+	began = time.Now()
+	err = parseFeed(body, doc, func(i *mod.RawItem) error {
+		if inFeed[i.Key] {
+			return nil
 		}
-		if s.StopGUID == i.GUID {
-			return ErrStopFeed
-		}
-		if err := processItem(ctx, processor, s.Pipeline, i); err != nil {
-			return err
+		inFeed[i.Key] = true
+		current = append(current, i.Key)
+		if i.Published != nil {
+			posted = append(posted, *i.Published)
 		}
 
-		s.newItems = append(s.newItems, &Item{
-			Sub:       s,
-			Title:     i.Title,
-			Content:   i.Content,
-			Link:      i.Link,
-			Published: i.Published.Unix(),
-		})
+		if stopAt != 0 && i.GUID == stopAt {
+			stopped = true
+		}
+		if stopped || seen[i.Key] {
+			return nil
+		}
+		fresh = append(fresh, i)
+		freshPos = append(freshPos, len(current)-1)
 		return nil
 	})
+	s.stats.Parse = time.Since(began)
+	s.stats.Bytes = body.n
+	s.stats.Parsed = len(current)
 
 	if err != nil {
+		s.stats.Failure = "parse"
+		if body.fail != nil {
+			s.stats.Failure = "too_big"
+		}
 		return err
 	}
-	if last != nil {
-		s.StopGUID = last.GUID
+
+	// The client timeout covers reading the body: pipeline modules only run
+	// once it is closed, so their time does not count against it.
+	res.Body.Close()
+	began = time.Now()
+	for k, i := range fresh {
+		if err := processItem(ctx, processor, s.Pipeline, i); err != nil {
+			s.stats.Pipeline = time.Since(began)
+			s.stats.Failure = "pipeline"
+			return err
+		}
+		s.newItems = append(s.newItems, newItem(s, i, dates, freshPos[k]))
+	}
+	s.stats.Pipeline = time.Since(began)
+	s.Seen = s.Seen.merge(current, globals.SeenWindow)
+	s.StopGUID = 0
+	if doc.Info != (FeedInfo{}) {
+		doc.Info.Title = cleanTitle(doc.Info.Title)
+		s.Feed = &doc.Info
 	}
 	s.ETag = etag
 	s.LastModified = lastModified
+	s.schedule(now, max(doc.TTL, cacheTTL(res.Header, now)), postingInterval(posted, now))
+	s.stats.MovedTo = movedTo(res)
 	return nil
 }
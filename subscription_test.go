@@ -1,9 +1,327 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gllera/srrb/mod"
 )
 
+func serveFeed(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetchSub(t *testing.T, s *Subscription) {
+	t.Helper()
+	if err := s.Fetch(context.Background(), newHTTPClient(), mod.New()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+}
+
+// rssItems returns an RSS feed with one item per guid, in order.
+func rssItems(guids ...string) string {
+	var b strings.Builder
+	b.WriteString(`<rss version="2.0"><channel><title>T</title>`)
+	for _, g := range guids {
+		fmt.Fprintf(&b, `<item><title>%s</title><guid>%s</guid></item>`, g, g)
+	}
+	b.WriteString(`</channel></rss>`)
+	return b.String()
+}
+
+func newTitles(s *Subscription) []string {
+	var titles []string
+	for _, i := range s.newItems {
+		titles = append(titles, i.Title)
+	}
+	return titles
+}
+
+func TestFetchDuplicateDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		feeds  [][]string
+		want   []string // new items of the last fetch
+	}{
+		{"unchanged", 100, [][]string{{"a", "b"}, {"a", "b"}}, nil},
+		{"new on top", 100, [][]string{{"a", "b"}, {"c", "a", "b"}}, []string{"c"}},
+		{"top item deleted", 100, [][]string{{"a", "b", "c"}, {"b", "c"}, {"d", "b", "c"}}, []string{"d"}},
+		{"reordered", 100, [][]string{{"a", "b", "c"}, {"c", "a", "b"}}, nil},
+		{"pinned post", 100, [][]string{{"p", "a", "b"}, {"p", "c", "a", "b"}}, []string{"c"}},
+		{"new below old", 100, [][]string{{"a", "b"}, {"a", "c", "b"}}, []string{"c"}},
+		{"duplicate in feed", 100, [][]string{{"a", "b", "a"}}, []string{"a", "b"}},
+		{"item reappears", 100, [][]string{{"a", "b"}, {"b"}, {"a", "b"}}, nil},
+		{"window smaller than feed", 1, [][]string{{"a", "b", "c"}, {"a", "b", "c"}}, nil},
+		{"forgotten beyond window", 2, [][]string{{"a", "b"}, {"c", "d"}, {"a", "c", "d"}}, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globals = &Globals{MaxFeedSize: 100, SeenWindow: tt.window}
+			var body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+
+			s := &Subscription{URL: srv.URL}
+			for _, feed := range tt.feeds {
+				body = rssItems(feed...)
+				fetchSub(t, s)
+			}
+			if got := newTitles(s); !slices.Equal(got, tt.want) {
+				t.Errorf("new items = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Items without guid nor link are told apart by title and content.
+	t.Run("no guid nor link", func(t *testing.T) {
+		globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+		items := func(titles ...string) string {
+			var b strings.Builder
+			b.WriteString(`<rss version="2.0"><channel><title>T</title>`)
+			for _, title := range titles {
+				fmt.Fprintf(&b, `<item><title>%s</title><description>About %s</description></item>`, title, title)
+			}
+			b.WriteString(`</channel></rss>`)
+			return b.String()
+		}
+		var body string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		defer srv.Close()
+
+		s := &Subscription{URL: srv.URL}
+		for _, step := range []struct {
+			titles []string
+			want   []string
+		}{
+			{[]string{"One", "Two", "Three"}, []string{"One", "Two", "Three"}},
+			{[]string{"Four", "One", "Two", "Three"}, []string{"Four"}},
+		} {
+			body = items(step.titles...)
+			fetchSub(t, s)
+			if got := newTitles(s); !slices.Equal(got, step.want) {
+				t.Errorf("new items = %v, want %v", got, step.want)
+			}
+		}
+	})
+}
+
+func TestFetchMigratesStopGUID(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+	srv := serveFeed(t, rssItems("c", "b", "a"))
+
+	s := &Subscription{URL: srv.URL, StopGUID: hash("b")}
+	fetchSub(t, s)
+
+	if got := newTitles(s); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("new items = %v, want [c]", got)
+	}
+	if s.StopGUID != 0 {
+		t.Errorf("StopGUID = %d, want cleared", s.StopGUID)
+	}
+	if len(s.Seen) != 3 {
+		t.Errorf("seen = %d ids, want 3", len(s.Seen))
+	}
+}
+
+func TestItemDates(t *testing.T) {
+	now := time.Unix(10000, 0)
+	at := func(sec int64) *time.Time {
+		t := time.Unix(sec, 0)
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		feedDate  time.Time
+		published *time.Time
+		pos       int
+		want      int64
+		source    string
+		orig      int64
+	}{
+		{"dated", time.Time{}, at(5000), 3, 5000, "", 0},
+		{"undated", time.Time{}, nil, 0, 10000, "fetch", 0},
+		{"undated keeps order", time.Time{}, nil, 2, 9998, "fetch", 0},
+		{"feed date", time.Unix(8000, 0), nil, 1, 7999, "feed", 0},
+		{"future feed date", time.Unix(20000, 0), nil, 1, 9999, "fetch", 0},
+		{"future", time.Time{}, at(50000), 0, 10000, "future", 50000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dates := itemDates{doc: &feedDoc{Date: tt.feedDate}, now: now}
+			it := &Item{}
+			dates.set(it, tt.published, tt.pos)
+			if it.Published != tt.want || it.DateSource != tt.source || it.OrigPublished != tt.orig {
+				t.Errorf("got (%d, %q, %d), want (%d, %q, %d)",
+					it.Published, it.DateSource, it.OrigPublished, tt.want, tt.source, tt.orig)
+			}
+		})
+	}
+}
+
+func TestFetchUndatedKeepsFeedOrder(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+	srv := serveFeed(t, rssItems("c", "b", "a"))
+
+	s := &Subscription{URL: srv.URL}
+	fetchSub(t, s)
+
+	items := slices.Clone(s.newItems)
+	slices.SortStableFunc(items, func(a, b *Item) int {
+		return int(a.Published - b.Published)
+	})
+	var titles []string
+	for _, i := range items {
+		titles = append(titles, i.Title)
+	}
+	if !slices.Equal(titles, []string{"a", "b", "c"}) {
+		t.Errorf("sorted = %v, want oldest first in feed order", titles)
+	}
+}
+
+func TestSizeLimiter(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"", false},
+		{"1234", false},
+		{"12345", true},
+		{strings.Repeat("x", 1000), true},
+	}
+
+	for _, tt := range tests {
+		r := &sizeLimiter{r: strings.NewReader(tt.input), max: 4}
+		data, err := io.ReadAll(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%d bytes: expected error", len(tt.input))
+			}
+			continue
+		}
+		if err != nil || string(data) != tt.input {
+			t.Errorf("%d bytes: got %q, %v", len(tt.input), data, err)
+		}
+	}
+}
+
+func TestFetchFeedTooBig(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 1, SeenWindow: 100}
+	srv := serveFeed(t, rssItems(strings.Split(strings.Repeat("item ", 100), " ")...))
+
+	s := &Subscription{URL: srv.URL}
+	err := s.Fetch(context.Background(), newHTTPClient(), mod.New())
+	if err == nil || !strings.Contains(err.Error(), "feed bigger than 1024 bytes") {
+		t.Errorf("err = %v, want size error", err)
+	}
+}
+
+// BenchmarkReadFeed compares the memory held per feed when the body is read
+// into a buffer of the max feed size against streaming it into the parser.
+func BenchmarkReadFeed(b *testing.B) {
+	globals = &Globals{MaxFeedSize: 5000, SeenWindow: 100}
+	var guids []string
+	for i := range 50 {
+		guids = append(guids, fmt.Sprintf("item-%d", i))
+	}
+	feed := []byte(rssItems(guids...))
+	nop := func(*mod.RawItem) error { return nil }
+
+	b.Run("buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			buf := make([]byte, globals.MaxFeedSize*(1<<10)+1)
+			n, _ := io.ReadFull(bytes.NewReader(feed), buf)
+			if err := parseFeed(bytes.NewReader(buf[:n]), &feedDoc{}, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			res := &http.Response{
+				Body:    io.NopCloser(bytes.NewReader(feed)),
+				Request: &http.Request{URL: &url.URL{}},
+			}
+			body, doc := readFeed(res)
+			if err := parseFeed(body, doc, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSeenSetJSON(t *testing.T) {
+	in := seenSet{hash64("a"), 0, ^uint64(0)}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out seenSet
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(in, out) {
+		t.Errorf("round trip = %v, want %v", out, in)
+	}
+	if err := json.Unmarshal([]byte(`"AAAA"`), &out); err == nil {
+		t.Error("expected error for truncated hash")
+	}
+}
+
+func TestFetchRecordsFeedInfo(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 100, SeenWindow: 100}
+	srv := serveFeed(t, `<rss version="2.0"><channel>
+    <title>Site &amp; &lt;b&gt;Co&lt;/b&gt;</title>
+    <link>/home</link>
+    <item><title>A</title><guid>a</guid></item>
+  </channel></rss>`)
+
+	s := &Subscription{URL: srv.URL + "/feed"}
+	fetchSub(t, s)
+
+	if s.Feed == nil {
+		t.Fatal("feed info not recorded")
+	}
+	if s.Feed.Title != "Site & Co" {
+		t.Errorf("title = %q, want cleaned title", s.Feed.Title)
+	}
+	if s.Feed.Link != srv.URL+"/home" {
+		t.Errorf("link = %q", s.Feed.Link)
+	}
+	if s.Feed.Icon != srv.URL+"/favicon.ico" {
+		t.Errorf("icon = %q, want favicon fallback", s.Feed.Icon)
+	}
+	if len(s.newItems) != 1 {
+		t.Errorf("new items = %d, want 1", len(s.newItems))
+	}
+}
+
 func TestStripControl(t *testing.T) {
 	tests := []struct {
 		input string
@@ -74,3 +392,25 @@ func stringMap(mapping func(rune) rune, s string) string {
 	}
 	return string(b)
 }
+
+func TestFetchPipelineOutsideTimeout(t *testing.T) {
+	globals = &Globals{MaxFeedSize: 1000, SeenWindow: 100}
+	var b strings.Builder
+	b.WriteString(`<rss version="2.0"><channel><title>T</title>`)
+	for i := range 10 {
+		fmt.Fprintf(&b, `<item><guid>%d</guid><description>%s</description></item>`, i, strings.Repeat("x", 16<<10))
+	}
+	b.WriteString(`</channel></rss>`)
+	srv := serveFeed(t, b.String())
+
+	// 10 items through a 0.15s module take longer than the 1s timeout,
+	// which must only bound the download.
+	s := &Subscription{URL: srv.URL, Pipeline: []string{"sleep 0.15; cat"}, HTTP: &HTTPOptions{Timeout: 1}}
+	fetchSub(t, s)
+	if len(s.newItems) != 10 {
+		t.Errorf("got %d items, want 10", len(s.newItems))
+	}
+	if s.stats.Pipeline < time.Second {
+		t.Errorf("pipeline took %v, want the module time", s.stats.Pipeline)
+	}
+}
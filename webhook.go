@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"text/template"
+
+	"github.com/gllera/srrb/backend"
+)
+
+// Webhook events.
+const (
+	eventItems  = "items"
+	eventHealth = "health"
+)
+
+// Webhook is an endpoint of the "webhooks" section of the config file,
+// notified after each fetch run of the new articles or of the subscriptions
+// whose health changed.
+type Webhook struct {
+	URL         string            `yaml:"url"`          // possibly a secret reference
+	Event       string            `yaml:"event"`        // items (default) or health
+	Tags        []string          `yaml:"tags"`         // only subscriptions with or under these tags
+	Headers     map[string]string `yaml:"headers"`      // values possibly secret references
+	Template    string            `yaml:"template"`     // text/template of the body, JSON payload by default
+	ContentType string            `yaml:"content-type"` // application/json by default
+
+	tmpl *template.Template
+}
+
+var webhooksCfg []*Webhook
+
+func init() {
+	backend.RegisterConfig("webhooks", &webhooksCfg)
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// webhookItem is an article in the payload of an items webhook.
+type webhookItem struct {
+	Sub       int    `json:"sub"`
+	SubTitle  string `json:"sub_title"`
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	Published int64  `json:"published"`
+}
+
+// webhookHealth is a subscription in the payload of a health webhook.
+type webhookHealth struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Previous string `json:"previous"`
+	Health   string `json:"health"`
+	Failures int    `json:"failures,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	tag string
+}
+
+// webhookPayload is the data of a webhook: its JSON body by default, and
+// the dot of its template.
+type webhookPayload struct {
+	Event         string           `json:"event"`
+	Items         []*webhookItem   `json:"items,omitempty"`
+	Subscriptions []*webhookHealth `json:"subscriptions,omitempty"`
+}
+
+// prepareWebhooks validates the configured webhooks and parses their
+// templates.
+func prepareWebhooks() error {
+	for i, h := range webhooksCfg {
+		if h.URL == "" {
+			return fmt.Errorf("webhook %d: url is required", i+1)
+		}
+		switch h.Event {
+		case "":
+			h.Event = eventItems
+		case eventItems, eventHealth:
+		default:
+			return fmt.Errorf("webhook %d: unknown event %q, want %s or %s", i+1, h.Event, eventItems, eventHealth)
+		}
+		if h.Template != "" {
+			t, err := template.New("webhook").Funcs(webhookFuncs).Parse(h.Template)
+			if err != nil {
+				return fmt.Errorf("webhook %d: %w", i+1, err)
+			}
+			h.tmpl = t
+		}
+	}
+	return nil
+}
+
+// wants reports whether h is about subscriptions with tag.
+func (h *Webhook) wants(tag string) bool {
+	return len(h.Tags) == 0 || slices.ContainsFunc(h.Tags, func(f string) bool { return tagMatches(tag, f) })
+}
+
+// payload returns what h is told of a run that stored items and changed
+// the health of subs, nil when there is nothing for it.
+func (h *Webhook) payload(items []*Item, subs []*webhookHealth) *webhookPayload {
+	p := &webhookPayload{Event: h.Event}
+	switch h.Event {
+	case eventItems:
+		for _, it := range items {
+			if h.wants(it.Sub.Tag) {
+				p.Items = append(p.Items, &webhookItem{
+					Sub:       it.Sub.ID,
+					SubTitle:  it.Sub.Title,
+					Title:     it.Title,
+					Link:      it.Link,
+					Published: it.Published,
+				})
+			}
+		}
+		if len(p.Items) == 0 {
+			return nil
+		}
+	case eventHealth:
+		for _, s := range subs {
+			if h.wants(s.tag) {
+				p.Subscriptions = append(p.Subscriptions, s)
+			}
+		}
+		if len(p.Subscriptions) == 0 {
+			return nil
+		}
+	}
+	return p
+}
+
+// send posts p to h, retrying transient failures like feed requests.
+func (h *Webhook) send(ctx context.Context, client *http.Client, p *webhookPayload) error {
+	var body bytes.Buffer
+	if h.tmpl != nil {
+		if err := h.tmpl.Execute(&body, p); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(p); err != nil {
+		return err
+	}
+	contentType := h.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	url, err := resolveSecret(h.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "SRRB/"+version)
+	for k, v := range h.Headers {
+		v, err := resolveSecret(v)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		req.Header.Set(k, v)
+	}
+
+	res, err := doRequest(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	if res.StatusCode/100 != 2 {
+		return &statusError{res.StatusCode, res.Status}
+	}
+	return nil
+}
+
+// healthChange returns how the health of s changed from was, nil if it
+// did not.
+func healthChange(s *Subscription, was string) *webhookHealth {
+	if s.health() == was {
+		return nil
+	}
+	return &webhookHealth{
+		ID:       s.ID,
+		Title:    s.Title,
+		URL:      s.URL,
+		Previous: was,
+		Health:   s.health(),
+		Failures: s.Failures,
+		Error:    s.FetchError,
+		tag:      s.Tag,
+	}
+}
+
+// notify calls the configured webhooks about a committed run. Failed
+// webhooks are logged, they do not fail the run.
+func notify(ctx context.Context, client *http.Client, items []*Item, changed []*webhookHealth) {
+	for i, h := range webhooksCfg {
+		p := h.payload(items, changed)
+		if p == nil {
+			continue
+		}
+		if err := h.send(ctx, client, p); err != nil {
+			slog.Error("webhook failed", "webhook", i+1, "event", h.Event, "err", err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookReceiver is a webhook endpoint recording the requests it gets.
+type hookReceiver struct {
+	mu       sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	failures int // first requests answered 503
+}
+
+func newHookReceiver(t *testing.T, failures int) (*hookReceiver, *httptest.Server) {
+	t.Helper()
+	hr := &hookReceiver{failures: failures}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		hr.mu.Lock()
+		defer hr.mu.Unlock()
+		if hr.failures > 0 {
+			hr.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		hr.bodies = append(hr.bodies, string(data))
+		hr.headers = append(hr.headers, r.Header.Clone())
+	}))
+	t.Cleanup(srv.Close)
+	return hr, srv
+}
+
+func TestPrepareWebhooks(t *testing.T) {
+	tests := []struct {
+		hook Webhook
+		ok   bool
+	}{
+		{Webhook{URL: "http://x"}, true},
+		{Webhook{URL: "http://x", Event: "health", Template: `{{json .Subscriptions}}`}, true},
+		{Webhook{}, false},
+		{Webhook{URL: "http://x", Event: "other"}, false},
+		{Webhook{URL: "http://x", Template: `{{.Items`}, false},
+	}
+	for _, tt := range tests {
+		h := tt.hook
+		webhooksCfg = []*Webhook{&h}
+		if err := prepareWebhooks(); (err == nil) != tt.ok {
+			t.Errorf("prepareWebhooks(%+v) = %v, want ok %v", tt.hook, err, tt.ok)
+		}
+	}
+	webhooksCfg = nil
+}
+
+func TestWebhookPayload(t *testing.T) {
+	tech := &Subscription{ID: 1, Title: "Tech", Tag: "tech/news"}
+	other := &Subscription{ID: 2, Title: "Other", Tag: "misc"}
+	items := []*Item{
+		{Sub: tech, Title: "a", Link: "http://a", Published: 10},
+		{Sub: other, Title: "b", Link: "http://b", Published: 20},
+	}
+	changed := []*webhookHealth{{ID: 2, Previous: healthOK, Health: healthFailing, tag: "misc"}}
+
+	all := &Webhook{Event: eventItems}
+	if p := all.payload(items, changed); p == nil || len(p.Items) != 2 || p.Subscriptions != nil {
+		t.Errorf("global items payload = %+v", p)
+	}
+	tagged := &Webhook{Event: eventItems, Tags: []string{"tech"}}
+	if p := tagged.payload(items, changed); p == nil || len(p.Items) != 1 || p.Items[0].Sub != 1 || p.Items[0].SubTitle != "Tech" {
+		t.Errorf("tagged items payload = %+v", p)
+	}
+	health := &Webhook{Event: eventHealth, Tags: []string{"tech"}}
+	if p := health.payload(items, changed); p != nil {
+		t.Errorf("health payload without matching changes = %+v", p)
+	}
+	health.Tags = nil
+	if p := health.payload(items, changed); p == nil || len(p.Subscriptions) != 1 {
+		t.Errorf("health payload = %+v", p)
+	}
+}
+
+func TestHealthChange(t *testing.T) {
+	globals = &Globals{MaxFailures: 2}
+	s := &Subscription{ID: 1}
+	if c := healthChange(s, s.health()); c != nil {
+		t.Errorf("change without one: %+v", c)
+	}
+
+	was := s.health()
+	s.failed(time.Now(), io.EOF)
+	if c := healthChange(s, was); c == nil || c.Previous != healthOK || c.Health != healthFailing || c.Error != "EOF" {
+		t.Errorf("started failing: %+v", c)
+	}
+
+	was = s.health()
+	s.succeeded(time.Now())
+	if c := healthChange(s, was); c == nil || c.Previous != healthFailing || c.Health != healthOK {
+		t.Errorf("recovered: %+v", c)
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	globals = &Globals{Retries: 2, RetryBackoff: time.Millisecond, RetryMaxWait: time.Millisecond}
+	secrets = map[string]string{"ntfy": "Bearer tk"}
+	t.Cleanup(func() { secrets = map[string]string{} })
+	hr, srv := newHookReceiver(t, 1)
+
+	h := &Webhook{
+		URL:         srv.URL,
+		Template:    `{{len .Items}} new: {{range .Items}}{{.Title}} {{end}}`,
+		ContentType: "text/plain",
+		Headers:     map[string]string{"Authorization": "secret:ntfy"},
+	}
+	webhooksCfg = []*Webhook{h}
+	t.Cleanup(func() { webhooksCfg = nil })
+	if err := prepareWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &webhookPayload{Event: eventItems, Items: []*webhookItem{{Title: "a"}, {Title: "b"}}}
+	if err := h.send(t.Context(), newHTTPClient(), p); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(hr.bodies) != 1 || hr.bodies[0] != "2 new: a b " {
+		t.Errorf("bodies = %q, want the retried templated body", hr.bodies)
+	}
+	if hd := hr.headers[0]; hd.Get("Authorization") != "Bearer tk" || hd.Get("Content-Type") != "text/plain" {
+		t.Errorf("headers = %v", hd)
+	}
+}
+
+func TestFetchNotifiesWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	globals = &Globals{PackSize: 1, MaxFeedSize: 100, SeenWindow: 100, Workers: 1, HostConcurrency: 1, MaxFailures: 10, Store: dir}
+	feed := serveFeed(t, rssItems("a", "b"))
+	items, itemsSrv := newHookReceiver(t, 0)
+	health, healthSrv := newHookReceiver(t, 0)
+	webhooksCfg = []*Webhook{
+		{URL: itemsSrv.URL},
+		{URL: healthSrv.URL, Event: eventHealth},
+	}
+	t.Cleanup(func() { webhooksCfg = nil })
+
+	db, err := NewDB(ctx, true)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.AddSubscription(&Subscription{Title: "T", URL: feed.URL, Tag: "tech"})
+	db.AddSubscription(&Subscription{Title: "Down", URL: "http://127.0.0.1:1/feed"})
+	if err := db.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	db.Close(ctx)
+
+	if err := (&FetchCmd{}).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(items.bodies) != 1 {
+		t.Fatalf("items webhook called %d times", len(items.bodies))
+	}
+	var p webhookPayload
+	if err := json.Unmarshal([]byte(items.bodies[0]), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != eventItems || len(p.Items) != 2 || p.Items[0].Sub != 1 {
+		t.Errorf("items payload = %s", items.bodies[0])
+	}
+
+	if len(health.bodies) != 1 || !strings.Contains(health.bodies[0], `"id":2`) || !strings.Contains(health.bodies[0], `"health":"failing"`) {
+		t.Errorf("health payloads = %q", health.bodies)
+	}
+}